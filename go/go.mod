@@ -0,0 +1,3 @@
+module github.com/ananaware/Data-Processing-System-implemented-in-Java-and-Go---MSCS-632-Assignment-6-/go
+
+go 1.21
@@ -1,151 +1,1950 @@
-package main
-
-import (
-    "bufio"
-    "fmt"
-    "os"
-    "strings"
-    "sync"
-    "time"
-)
-
-// Task represents a unit of work in the Go Data Processing System.
-// It has an ID and a piece of text data to process.
-type Task struct {
-    ID   int
-    Data string
-}
-
-// PoisonPillID is the special ID used to signal workers to stop.
-const PoisonPillID = -1
-
-// worker is a goroutine function that:
-//
-//   - reads Task values from the tasks channel,
-//   - simulates processing (sleep),
-//   - transforms the data (to upper case, compute length),
-//   - appends a result string to the shared results slice,
-//   - logs its activity.
-//
-// When it receives a Task with ID == PoisonPillID, it logs a shutdown
-// message and returns, which decrements the WaitGroup counter.
-func worker(workerID int, tasks <-chan Task, results *[]string, mu *sync.Mutex, wg *sync.WaitGroup) {
-    defer wg.Done()
-
-    fmt.Printf("Worker-%d started.\n", workerID)
-
-    for task := range tasks {
-        // Check for poison pill
-        if task.ID == PoisonPillID {
-            fmt.Printf("Worker-%d received poison pill. Shutting down.\n", workerID)
-            break
-        }
-
-        fmt.Printf("Worker-%d processing Task-%d\n", workerID, task.ID)
-
-        // Simulate computational work with a random delay between 200–500 ms
-        delay := 200 + time.Duration(time.Now().UnixNano()%300)
-        time.Sleep(delay * time.Millisecond)
-
-        // Processing: uppercase the data and get its length
-        input := task.Data
-        output := strings.ToUpper(input)
-        length := len(output)
-
-        // Build result line
-        resultLine := fmt.Sprintf(
-            "Worker-%d processed Task-%d: %q -> %q (len=%d, delay=%dms)",
-            workerID, task.ID, input, output, length, delay,
-        )
-
-        // Append to shared results slice safely
-        mu.Lock()
-        *results = append(*results, resultLine)
-        mu.Unlock()
-
-        // Log success
-        fmt.Println(resultLine)
-    }
-
-    fmt.Printf("Worker-%d completed.\n", workerID)
-}
-
-func main() {
-    // Configuration
-    numWorkers := 4
-    numTasks := 10
-    outputFile := "go_results.txt"
-
-    fmt.Println("Starting Data Processing System in Go...")
-    fmt.Printf("Number of workers: %d, number of tasks: %d\n", numWorkers, numTasks)
-
-    // Channel acts as our thread-safe task queue
-    tasks := make(chan Task)
-
-    // Shared results slice + mutex for safe concurrent access
-    var results []string
-    var mu sync.Mutex
-
-    // WaitGroup to wait for all workers to finish
-    var wg sync.WaitGroup
-    wg.Add(numWorkers)
-
-    // Start worker goroutines
-    for i := 1; i <= numWorkers; i++ {
-        go worker(i, tasks, &results, &mu, &wg)
-    }
-
-    // Producer: add normal tasks to the channel
-    for i := 1; i <= numTasks; i++ {
-        data := fmt.Sprintf("task_data_%d", i)
-        task := Task{ID: i, Data: data}
-        fmt.Printf("Main goroutine adding Task-%d (%s) to the channel.\n", i, data)
-        tasks <- task
-    }
-
-    // Add one poison pill per worker
-    fmt.Println("Main goroutine adding poison pills to the channel...")
-    for i := 0; i < numWorkers; i++ {
-        tasks <- Task{ID: PoisonPillID, Data: "POISON"}
-    }
-
-    // We can close the channel after sending all tasks + poison pills.
-    close(tasks)
-
-    // Wait for all workers to finish
-    wg.Wait()
-
-    // Write results to file
-    fmt.Printf("Writing results to file: %s\n", outputFile)
-    if err := writeResultsToFile(outputFile, results); err != nil {
-        fmt.Printf("Error writing results to file: %v\n", err)
-    } else {
-        fmt.Printf("Results successfully written to %s\n", outputFile)
-    }
-
-    fmt.Println("Go Data Processing System finished.")
-}
-
-// writeResultsToFile writes all result lines to the given file,
-// one line per result. It demonstrates Go-style error handling:
-// functions return 'error' and the caller checks 'if err != nil'.
-func writeResultsToFile(filename string, results []string) error {
-    file, err := os.Create(filename)
-    if err != nil {
-        return err
-    }
-    defer file.Close()
-
-    writer := bufio.NewWriter(file)
-    for _, line := range results {
-        if _, err := writer.WriteString(line + "\n"); err != nil {
-            return err
-        }
-    }
-
-    if err := writer.Flush(); err != nil {
-        return err
-    }
-
-    return nil
-}
+// The command-line front end of the Go Data Processing System. It
+// parses flags, loads or generates the tasks, runs them through the
+// processor worker pool, and writes the results to a file.
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log/slog"
+    "math"
+    "math/rand"
+    "net"
+    "net/http"
+    "os"
+    "os/signal"
+    "regexp"
+    "runtime"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/ananaware/Data-Processing-System-implemented-in-Java-and-Go---MSCS-632-Assignment-6-/go/processor"
+)
+
+// progressInterval is how often the progress line is refreshed.
+const progressInterval = 500 * time.Millisecond
+
+// memSampleInterval is how often -stats samples the heap size.
+const memSampleInterval = 50 * time.Millisecond
+
+// dryRunPreview is how many tasks -dry-run lists.
+const dryRunPreview = 5
+
+// Exit codes. Usage errors use 2 like the flag package, so a run in
+// which some tasks failed gets its own code.
+const (
+    exitError        = 1 // input, output or dead-letter I/O failed
+    exitUsage        = 2 // invalid flags
+    exitTaskFailures = 3 // the run completed but some tasks failed
+)
+
+// config holds the run-time settings of the system, as parsed
+// from the command line.
+type config struct {
+    numWorkers    int
+    workersFrom   string
+    numTasks      int
+    tasksSet      bool // -tasks or -gen was given, so piped stdin is not read
+    inputFiles    listFlag
+    inputFormat   string
+    idMode        string
+    column        string
+    idColumn      string
+    tagColumns    listFlag
+    noHeader      bool
+    strict        bool
+    outputFile    string
+    format        string
+    timeout       time.Duration
+    ordered       bool
+    bufferSize    int
+    maxRetries    int
+    backoff       processor.Backoff
+    deadLetter    string
+    transform     listFlag
+    stages        stageFlag
+    seed          int64
+    logFormat     string
+    logLevel      slog.Level
+    pipeline      processor.Pipeline
+    fanOut        string
+    decode        string
+    priorities    bool
+    rate          float64
+    batchSize     int
+    maxWorkers    int
+    maxInFlight   int
+    scaleIdle     time.Duration
+    taskTimeout   time.Duration
+    idleTimeout   time.Duration
+    budget        time.Duration
+    drain         bool
+    quiet         bool
+    summary       bool
+    stream        bool
+    dryRun        bool
+    dedupe        bool
+    filter        *regexp.Regexp
+    checkpoint    string
+    resume        bool
+    delay         bool
+    mode          string
+    sequential    bool
+    deterministic bool
+    memStats      bool
+    metricsAddr   string
+    topWords      int
+    failFast      bool
+    maxFailures   int
+    maxFailRate   float64
+    splitOutput   bool
+    sortBy        string
+    template      string
+    delimiter     string
+    inputDir      string
+    recursive     bool
+    guarantee     string
+    maxDataLen    int
+    onOversize    string
+    dispatch      string
+    repeat        int
+    limit         int
+    tee           bool
+    sampleRate    int
+    outputs       []output
+    appendOut     bool
+    validateOut   bool
+    noAtomic      bool
+    gen           string
+    genLength     int
+    genLong       int
+    genShare      float64
+    genDict       string
+    genTemplate   processor.TemplateSource
+    shuffle       bool
+    manifest      string
+    checksum      bool
+    noColor       bool
+    normalize     bool
+}
+
+// output is one destination for the results: a path ("-" for standard
+// output) and the format to write there.
+type output struct {
+    path   string
+    format string
+}
+
+// listFlag collects the values of a list flag such as -transform or
+// -input. Each value may hold a comma-separated list, and repeated
+// flags append to the list; the first explicit value replaces the
+// default.
+type listFlag struct {
+    values []string
+    set    bool
+}
+
+// String implements flag.Value.
+func (f *listFlag) String() string {
+    return strings.Join(f.values, ",")
+}
+
+// Set implements flag.Value.
+func (f *listFlag) Set(value string) error {
+    if !f.set {
+        f.values, f.set = nil, true
+    }
+    for _, v := range strings.Split(value, ",") {
+        if v = strings.TrimSpace(v); v != "" {
+            f.values = append(f.values, v)
+        }
+    }
+    return nil
+}
+
+// stage is one -stage of a multi-stage run: its transforms and worker
+// count.
+type stage struct {
+    pipeline    processor.Pipeline
+    workers     int
+    workersFrom string
+}
+
+// stageFlag collects the -stage flags, one stage per flag in order.
+// Unlike a listFlag it does not split a value on commas, which
+// separate the stage's transforms.
+type stageFlag []stage
+
+// String implements flag.Value.
+func (f *stageFlag) String() string {
+    specs := make([]string, len(*f))
+    for i, st := range *f {
+        specs[i] = st.pipeline.String()
+        if st.workers > 0 {
+            specs[i] += ":" + strconv.Itoa(st.workers)
+        }
+    }
+    return strings.Join(specs, " ")
+}
+
+// Set implements flag.Value, parsing "transform,...[:workers]".
+func (f *stageFlag) Set(value string) error {
+    spec, workers := value, 0
+    if i := strings.LastIndex(value, ":"); i >= 0 {
+        n, err := strconv.Atoi(value[i+1:])
+        if err != nil || n <= 0 {
+            return fmt.Errorf("worker count %q is not a positive integer", value[i+1:])
+        }
+        spec, workers = value[:i], n
+    }
+    var names []string
+    for _, name := range strings.Split(spec, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            names = append(names, name)
+        }
+    }
+    pipeline, err := processor.NewPipeline(names...)
+    if err != nil {
+        return err
+    }
+    *f = append(*f, stage{pipeline: pipeline, workers: workers})
+    return nil
+}
+
+// parseConfig reads the command-line flags into a config and
+// validates them. Invalid values are reported as an error so that
+// main can print a clear message and exit.
+func parseConfig() (config, error) {
+    var cfg config
+
+    flag.StringVar(&cfg.mode, "mode", "process", `"process" writes one result per task; "wordfreq" counts words across all tasks and writes the -top most frequent`)
+    flag.IntVar(&cfg.topWords, "top", 10, "number of words -mode wordfreq writes (0 for all)")
+    flag.IntVar(&cfg.numWorkers, "workers", 0, "number of worker goroutines (default: GOMAXPROCS, the CPUs the process may use)")
+    flag.BoolVar(&cfg.sequential, "sequential", false, "process tasks one at a time, in order, without goroutines or channels (for debugging); implies -deterministic")
+    flag.BoolVar(&cfg.deterministic, "deterministic", false, "leave the worker, delay and timing fields out of the output, so that an -ordered run writes the same bytes as -sequential")
+    flag.IntVar(&cfg.numTasks, "tasks", 10, "number of tasks to generate")
+    flag.BoolVar(&cfg.shuffle, "shuffle", false, "dispatch the loaded or generated tasks in a random order, reproducible with -seed (not with standard input)")
+    flag.StringVar(&cfg.gen, "gen", "numbered", `generator for -tasks: "numbered" (task_data_<id>), "random" letters, "skewed" (short and long random letters), "words" from -gen-dict or "template" (-gen-template)`)
+    flag.IntVar(&cfg.genLength, "gen-length", 16, "with -gen random, letters per task; with -gen words, words per task; with -gen skewed, letters per short task")
+    flag.IntVar(&cfg.genLong, "gen-long-length", 4096, "with -gen skewed, letters per long task")
+    flag.Float64Var(&cfg.genShare, "gen-long-share", 0.2, "with -gen skewed, the fraction of tasks, from 0 to 1, that are long")
+    flag.StringVar(&cfg.genDict, "gen-dict", "", "with -gen words, the dictionary file to pick words from, one per line")
+    genTemplate := flag.String("gen-template", "", "with -gen template, a Go text/template for each task's data, e.g. 'order {{.ID}}'")
+    flag.IntVar(&cfg.limit, "limit", 0, "process at most this many tasks, the first ones loaded, stopping the input reader there (0 means no limit)")
+    flag.IntVar(&cfg.repeat, "repeat", 1, "process the loaded or generated tasks this many times over, with distinct IDs (not with standard input)")
+    flag.Var(&cfg.inputFiles, "input", "comma-separated files to read tasks from, one per line, in order; repeatable. - reads standard input.\n"+
+        "Precedence: -input files, then -input -, then explicit -tasks or -gen, then piped standard input, then -tasks synthetic tasks")
+    flag.StringVar(&cfg.outputFile, "output", "go_results.txt", `file to write results to ("-" for standard output); with several -format values, one comma-separated path per format`)
+    flag.StringVar(&cfg.format, "format", "text", "output format: text, json, or csv, or a comma-separated list such as text,json")
+    lineTemplate := flag.String("template", processor.DefaultTemplate, "text/template rendered against each Result to produce a -format text line")
+    flag.StringVar(&cfg.delimiter, "delimiter", "lf", "record delimiter for -format text: lf, crlf, or nul")
+    flag.DurationVar(&cfg.timeout, "timeout", 0, "cancel processing after this duration (0 means no timeout)")
+    flag.BoolVar(&cfg.ordered, "ordered", false, "sort results by task ID before writing")
+    flag.StringVar(&cfg.sortBy, "sort", "none", `order of the written results: "id" (as -ordered), "length" (of the output), "input" (alphabetically), or "none" (completion order); ties break by task ID`)
+    flag.IntVar(&cfg.bufferSize, "buffer", 0, "capacity of the task channel (0 means unbuffered)")
+    flag.IntVar(&cfg.maxRetries, "max-retries", 2, "times to retry a task whose processing fails")
+    flag.StringVar(&cfg.backoff.Strategy, "backoff", "fixed", `wait between retries: "none", "fixed" (-backoff-base), "linear" (-backoff-base times the retry number) or "exponential" (doubling, with jitter)`)
+    flag.DurationVar(&cfg.backoff.Base, "backoff-base", 100*time.Millisecond, "base wait between retries for -backoff")
+    flag.DurationVar(&cfg.backoff.Max, "backoff-max", 5*time.Second, "longest wait between retries for -backoff (0 means no cap)")
+    flag.StringVar(&cfg.deadLetter, "deadletter", "", "file to write tasks that could not be processed to")
+    flag.IntVar(&cfg.maxDataLen, "max-data-len", 0, "longest task data allowed, in characters (0 means no limit); see -on-oversize")
+    flag.StringVar(&cfg.onOversize, "on-oversize", "truncate", `what to do with task data over -max-data-len: "truncate" or "reject" (record the task as failed)`)
+    flag.StringVar(&cfg.guarantee, "guarantee", "at-least-once", `processing guarantee: "at-least-once" or "exactly-once" (never process a task ID twice)`)
+    flag.BoolVar(&cfg.failFast, "fail-fast", false, "stop all workers at the first task that fails after its retries")
+    flag.IntVar(&cfg.maxFailures, "max-failures", 0, "abort the run once more than this many tasks have failed (0 means no limit)")
+    flag.Float64Var(&cfg.maxFailRate, "max-failure-rate", 0, "abort the run once more than this percentage of the finished tasks have failed, checked from the 10th task on (0 means no limit)")
+    cfg.transform.values = []string{"upper"}
+    flag.Var(&cfg.transform, "transform",
+        "comma-separated transforms applied in order; repeatable (default upper; choose from "+
+            strings.Join(processor.TransformNames(), ", ")+`; "" for none)`)
+    flag.Var(&cfg.stages, "stage",
+        `run the tasks through a pipeline of worker pools, one per -stage "transform,...[:workers]" in order, each stage's results feeding the next (workers default to -workers); replaces -transform`)
+    flag.BoolVar(&cfg.normalize, "normalize", false, "collapse runs of whitespace in each task's data to single spaces and trim both ends before the transforms (results keep the raw input)")
+    flag.BoolVar(&cfg.delay, "simulate-delay", true, "sleep 200-500ms per task to simulate work; false runs the transform at full speed")
+    flag.StringVar(&cfg.decode, "decode", "", "decode each task's data before the transform: "+strings.Join(processor.DecoderNames(), ", ")+` ("" for none); data that does not decode fails the task`)
+    flag.StringVar(&cfg.fanOut, "fan-out", "", "split each transformed output into one result per part: "+strings.Join(processor.FanOutNames(), ", ")+` ("" for none)`)
+    flag.Int64Var(&cfg.seed, "seed", 0, "seed for the simulated delays (default: current time)")
+    flag.StringVar(&cfg.logFormat, "log-format", "text", "log output format: text or json")
+    flag.BoolVar(&cfg.noColor, "no-color", false, "do not color the text log, which is otherwise colored when standard error is a terminal and NO_COLOR is unset")
+    flag.TextVar(&cfg.logLevel, "log-level", slog.LevelInfo, "minimum log level: debug, info, warn, or error")
+    flag.StringVar(&cfg.inputDir, "input-dir", "", "directory to read tasks from, one per file with the whole file as data (instead of -input)")
+    flag.BoolVar(&cfg.recursive, "recursive", false, "with -input-dir, also read the files in its subdirectories")
+    flag.StringVar(&cfg.idMode, "id-mode", "sequential", `how tasks without an ID of their own are numbered: "sequential" (input order) or "content" (a hash of the data, stable across runs)`)
+    flag.StringVar(&cfg.inputFormat, "input-format", "text", `input format: "text" (one task per line), "jsonl" ({"id":..,"data":".."} per line) or "csv"`)
+    flag.StringVar(&cfg.column, "column", "1", "with -input-format csv, the column holding the task data: a 1-based index or a header name")
+    flag.StringVar(&cfg.idColumn, "id-column", "", "with -input-format csv, the column holding task IDs (index or header name); empty numbers rows in order")
+    flag.Var(&cfg.tagColumns, "tag-columns", "with -input-format csv, comma-separated columns (index or header name) copied into each task's tags")
+    flag.BoolVar(&cfg.noHeader, "no-header", false, "with -input-format csv, treat the first row as data rather than a header")
+    flag.BoolVar(&cfg.strict, "strict", false, "abort on an invalid jsonl line or csv row, or an unreadable -input-dir file, instead of skipping it")
+    flag.BoolVar(&cfg.priorities, "priorities", false, `parse a "<priority>:" prefix on each -input line; higher priorities run first`)
+    filter := flag.String("filter", "", "only process tasks whose data matches this regular expression")
+    flag.BoolVar(&cfg.dedupe, "dedupe", false, "skip input lines whose data repeats an earlier line, keeping the first")
+    flag.Float64Var(&cfg.rate, "rate", 0, "maximum tasks dispatched per second (0 means unlimited)")
+    flag.IntVar(&cfg.batchSize, "batch", 0, "group tasks into batches of this size for the workers (0 disables batching)")
+    flag.StringVar(&cfg.dispatch, "dispatch", "shared", `how tasks reach the workers: "shared" (one channel, the next free worker takes each task) or "roundrobin" (a channel per worker, dealt to in turn)`)
+    flag.IntVar(&cfg.maxInFlight, "max-inflight", 0, "process at most this many tasks at once, however many workers there are (0 means one per worker)")
+    flag.IntVar(&cfg.maxWorkers, "max-workers", 0, "autoscale up to this many workers while the -buffer backlog is large (0 disables)")
+    flag.DurationVar(&cfg.scaleIdle, "scale-idle", time.Second, "how long an autoscaled worker may sit idle before exiting")
+    flag.BoolVar(&cfg.drain, "drain-on-shutdown", false, "on a signal or -timeout, start no more tasks but finish every task already queued for the workers before writing")
+    flag.DurationVar(&cfg.budget, "budget", 0, "start tasks for at most this long, then finish the ones in hand and write what completed (0 means no budget)")
+    flag.DurationVar(&cfg.idleTimeout, "idle-timeout", 0, "stop once every worker has waited this long without a task, e.g. for a pipe that goes quiet (0 means wait for the input to end)")
+    flag.DurationVar(&cfg.taskTimeout, "task-timeout", 0, "abandon a task that takes longer than this and record it as failed (0 means no limit)")
+    flag.StringVar(&cfg.metricsAddr, "metrics-addr", "", `serve live Prometheus metrics at http://ADDR/metrics while processing (e.g. ":9090")`)
+    flag.BoolVar(&cfg.memStats, "stats", false, "print peak heap, total allocations and GC cycles after the run")
+    flag.BoolVar(&cfg.quiet, "quiet", false, "do not print the progress line to standard error")
+    flag.BoolVar(&cfg.dryRun, "dry-run", false, "load and count the tasks, print a preview of the first few, and exit without processing")
+    flag.StringVar(&cfg.checkpoint, "checkpoint", "", "file to record completed task IDs in, one per line, removed after a clean run (needs -stream)")
+    flag.BoolVar(&cfg.resume, "resume", false, "skip the tasks recorded in -checkpoint and append to -output instead of replacing it")
+    flag.BoolVar(&cfg.noAtomic, "no-atomic", false, "write output files in place instead of to a temporary file renamed into place once complete")
+    flag.BoolVar(&cfg.validateOut, "validate-output", false, "after writing, read every -output file back and fail unless it decodes and holds one record per result")
+    flag.BoolVar(&cfg.appendOut, "append", false, "add results to the end of existing -output files instead of replacing them (text and csv only)")
+    flag.BoolVar(&cfg.stream, "stream", false, "write each result to -output as soon as it completes instead of all at the end")
+    flag.IntVar(&cfg.sampleRate, "sample-rate", 1, "write only one in every N tasks' results (by dispatch order, so reproducible), while the summary counts them all")
+    flag.BoolVar(&cfg.tee, "tee", false, "also write each result to standard output as it completes, in the -output format (streams like -stream)")
+    flag.BoolVar(&cfg.checksum, "checksum", false, "add each output's SHA-256 to the results and write a <output>.sha256 file for every -output file")
+    flag.StringVar(&cfg.manifest, "manifest", "", "write a JSON record of the run (flags, timestamps, task counts, output files) to this file")
+    flag.BoolVar(&cfg.splitOutput, "split-output", false, "have each worker write its own results file, named after -output (e.g. go_results_worker_1.txt)")
+    flag.BoolVar(&cfg.summary, "summary", false, "append the run summary to the -output file (text format only)")
+    configFile := flag.String("config", "", "JSON file of flag values, e.g. {\"workers\": 8, \"transform\": \"lower,trim\"}.\n"+
+        "Precedence: defaults < config file < "+envPrefix+"* environment variables < command-line flags")
+    flag.Parse()
+
+    // The environment goes first: applyConfigFile leaves alone every
+    // flag already set, so the variables win over the file.
+    if err := applyEnv(os.Environ()); err != nil {
+        return cfg, err
+    }
+    if *configFile != "" {
+        if err := applyConfigFile(*configFile); err != nil {
+            return cfg, fmt.Errorf("-config %s: %w", *configFile, err)
+        }
+    }
+
+    // Fall back to a time-based seed unless -seed was given explicitly,
+    // so that 0 is still a usable seed, and to one worker per usable
+    // CPU unless -workers was.
+    seedSet, workersSet := false, false
+    flag.Visit(func(f *flag.Flag) {
+        switch f.Name {
+        case "seed":
+            seedSet = true
+        case "workers":
+            workersSet = true
+        case "tasks", "gen":
+            cfg.tasksSet = true
+        }
+    })
+    if !seedSet {
+        cfg.seed = time.Now().UnixNano()
+    }
+    if cfg.sequential {
+        cfg.deterministic = true
+    }
+    cfg.workersFrom = "-workers"
+    if !workersSet {
+        cfg.numWorkers, cfg.workersFrom = runtime.GOMAXPROCS(0), "GOMAXPROCS"
+    }
+
+    if cfg.numWorkers <= 0 {
+        return cfg, fmt.Errorf("-workers must be a positive integer, got %d", cfg.numWorkers)
+    }
+    if cfg.numTasks <= 0 {
+        return cfg, fmt.Errorf("-tasks must be a positive integer, got %d", cfg.numTasks)
+    }
+    if len(cfg.inputFiles.values) > 1 && slices.Contains(cfg.inputFiles.values, "-") {
+        return cfg, fmt.Errorf("-input - cannot be combined with other input files")
+    }
+    if cfg.inputDir != "" && len(cfg.inputFiles.values) > 0 {
+        return cfg, fmt.Errorf("-input-dir cannot be combined with -input")
+    }
+    if cfg.recursive && cfg.inputDir == "" {
+        return cfg, fmt.Errorf("-recursive needs -input-dir")
+    }
+    if cfg.inputFormat != "text" && cfg.inputFormat != "jsonl" && cfg.inputFormat != "csv" {
+        return cfg, fmt.Errorf("unknown -input-format %q (choose text, jsonl or csv)", cfg.inputFormat)
+    }
+    if cfg.idMode != "sequential" && cfg.idMode != "content" {
+        return cfg, fmt.Errorf("unknown -id-mode %q (choose sequential or content)", cfg.idMode)
+    }
+    formats := strings.Split(cfg.format, ",")
+    paths := []string{cfg.outputFile}
+    if len(formats) > 1 {
+        paths = strings.Split(cfg.outputFile, ",")
+        if len(paths) != len(formats) {
+            return cfg, fmt.Errorf("-format lists %d formats but -output has %d paths", len(formats), len(paths))
+        }
+    }
+    hasText := false
+    for i, format := range formats {
+        if _, ok := processor.Encoders[format]; !ok {
+            return cfg, fmt.Errorf("unknown -format %q", format)
+        }
+        hasText = hasText || format == "text"
+        cfg.outputs = append(cfg.outputs, output{path: paths[i], format: format})
+    }
+    // The rest of the run treats the first output as the output.
+    cfg.format, cfg.outputFile = formats[0], paths[0]
+    // -tee streams the results to the -output file and standard output
+    // together.
+    if cfg.tee {
+        if cfg.outputFile == "-" || len(cfg.outputs) > 1 {
+            return cfg, fmt.Errorf("-tee needs a single -output file")
+        }
+        if cfg.ordered || cfg.splitOutput || cfg.mode == "wordfreq" {
+            return cfg, fmt.Errorf("-tee cannot be combined with -ordered, -split-output or -mode wordfreq")
+        }
+        cfg.stream = true
+    }
+    if len(cfg.outputs) > 1 && (cfg.stream || cfg.splitOutput || cfg.mode == "wordfreq") {
+        return cfg, fmt.Errorf("several -format values cannot be combined with -stream, -split-output or -mode wordfreq")
+    }
+    if *lineTemplate != processor.DefaultTemplate {
+        if !hasText {
+            return cfg, fmt.Errorf("-template only applies to -format text")
+        }
+        if _, err := processor.TemplateEncoder(*lineTemplate); err != nil {
+            return cfg, fmt.Errorf("invalid -template: %w", err)
+        }
+        cfg.template = *lineTemplate
+    }
+    if _, ok := processor.Delimiters[cfg.delimiter]; !ok {
+        return cfg, fmt.Errorf("unknown -delimiter %q (want lf, crlf, or nul)", cfg.delimiter)
+    }
+    if cfg.delimiter != "lf" && !hasText {
+        return cfg, fmt.Errorf("-delimiter only applies to -format text")
+    }
+    pipeline, err := processor.NewPipeline(cfg.transform.values...)
+    if err != nil {
+        return cfg, fmt.Errorf("invalid -transform: %w", err)
+    }
+    cfg.pipeline = pipeline
+    // -normalize is the built-in normalize transform run first, so the
+    // results record it and keep the raw data as their input.
+    if cfg.normalize {
+        cfg.pipeline = append(processor.Pipeline{"normalize"}, cfg.pipeline...)
+        if len(cfg.stages) > 0 {
+            cfg.stages[0].pipeline = append(processor.Pipeline{"normalize"}, cfg.stages[0].pipeline...)
+        }
+    }
+    if len(cfg.stages) > 0 {
+        if cfg.transform.set {
+            return cfg, fmt.Errorf("-stage cannot be combined with -transform")
+        }
+        if cfg.checkpoint != "" || cfg.mode == "wordfreq" || cfg.drain {
+            return cfg, fmt.Errorf("-stage cannot be combined with -checkpoint, -mode wordfreq or -drain-on-shutdown")
+        }
+        for i := range cfg.stages {
+            cfg.stages[i].workersFrom = "-stage"
+            if cfg.stages[i].workers == 0 {
+                cfg.stages[i].workers, cfg.stages[i].workersFrom = cfg.numWorkers, cfg.workersFrom
+            }
+        }
+    }
+    if _, ok := processor.FanOuts[cfg.fanOut]; cfg.fanOut != "" && !ok {
+        return cfg, fmt.Errorf("unknown -fan-out %q (choose from %s)", cfg.fanOut, strings.Join(processor.FanOutNames(), ", "))
+    }
+    if _, ok := processor.Decoders[cfg.decode]; cfg.decode != "" && !ok {
+        return cfg, fmt.Errorf("unknown -decode %q (choose from %s)", cfg.decode, strings.Join(processor.DecoderNames(), ", "))
+    }
+    if cfg.decode != "" && cfg.mode == "wordfreq" {
+        return cfg, fmt.Errorf("-decode cannot be combined with -mode wordfreq")
+    }
+    if cfg.fanOut != "" && cfg.mode == "wordfreq" {
+        return cfg, fmt.Errorf("-fan-out cannot be combined with -mode wordfreq")
+    }
+    if *filter != "" {
+        re, err := regexp.Compile(*filter)
+        if err != nil {
+            return cfg, fmt.Errorf("invalid -filter: %w", err)
+        }
+        cfg.filter = re
+    }
+    if cfg.logFormat != "text" && cfg.logFormat != "json" {
+        return cfg, fmt.Errorf("unknown -log-format %q (choose text or json)", cfg.logFormat)
+    }
+    if cfg.bufferSize < 0 {
+        return cfg, fmt.Errorf("-buffer must not be negative, got %d", cfg.bufferSize)
+    }
+    if cfg.maxInFlight < 0 {
+        return cfg, fmt.Errorf("-max-inflight must not be negative, got %d", cfg.maxInFlight)
+    }
+    if cfg.maxWorkers != 0 {
+        if cfg.maxWorkers < cfg.numWorkers {
+            return cfg, fmt.Errorf("-max-workers (%d) must not be less than -workers (%d)", cfg.maxWorkers, cfg.numWorkers)
+        }
+        if cfg.bufferSize == 0 {
+            return cfg, fmt.Errorf("-max-workers needs a positive -buffer to measure the backlog")
+        }
+        if cfg.batchSize > 0 {
+            return cfg, fmt.Errorf("-max-workers cannot be combined with -batch")
+        }
+    }
+    if cfg.batchSize < 0 {
+        return cfg, fmt.Errorf("-batch must not be negative, got %d", cfg.batchSize)
+    }
+    if cfg.rate < 0 {
+        return cfg, fmt.Errorf("-rate must not be negative, got %v", cfg.rate)
+    }
+    if cfg.maxRetries < 0 {
+        return cfg, fmt.Errorf("-max-retries must not be negative, got %d", cfg.maxRetries)
+    }
+    switch cfg.backoff.Strategy {
+    case "none", "fixed", "linear", "exponential":
+    default:
+        return cfg, fmt.Errorf("unknown -backoff %q (choose none, fixed, linear or exponential)", cfg.backoff.Strategy)
+    }
+    if cfg.backoff.Base <= 0 || cfg.backoff.Max < 0 {
+        return cfg, fmt.Errorf("-backoff-base must be positive and -backoff-max not negative, got %v and %v", cfg.backoff.Base, cfg.backoff.Max)
+    }
+    if cfg.maxFailures < 0 {
+        return cfg, fmt.Errorf("-max-failures must not be negative, got %d", cfg.maxFailures)
+    }
+    if cfg.maxFailRate < 0 || cfg.maxFailRate > 100 {
+        return cfg, fmt.Errorf("-max-failure-rate must be a percentage between 0 and 100, got %v", cfg.maxFailRate)
+    }
+    if cfg.budget < 0 {
+        return cfg, fmt.Errorf("-budget must not be negative, got %v", cfg.budget)
+    }
+    if cfg.drain && cfg.mode == "wordfreq" {
+        return cfg, fmt.Errorf("-drain-on-shutdown cannot be combined with -mode wordfreq")
+    }
+    if cfg.idleTimeout < 0 {
+        return cfg, fmt.Errorf("-idle-timeout must not be negative, got %v", cfg.idleTimeout)
+    }
+    if cfg.taskTimeout < 0 {
+        return cfg, fmt.Errorf("-task-timeout must not be negative, got %v", cfg.taskTimeout)
+    }
+    if cfg.timeout < 0 {
+        return cfg, fmt.Errorf("-timeout must not be negative, got %v", cfg.timeout)
+    }
+    switch cfg.gen {
+    case "numbered", "random":
+    case "skewed":
+        if cfg.genLong <= 0 {
+            return cfg, fmt.Errorf("-gen-long-length must be a positive integer, got %d", cfg.genLong)
+        }
+        if cfg.genShare < 0 || cfg.genShare > 1 {
+            return cfg, fmt.Errorf("-gen-long-share must be between 0 and 1, got %g", cfg.genShare)
+        }
+    case "words":
+        if cfg.genDict == "" {
+            return cfg, fmt.Errorf("-gen words needs -gen-dict")
+        }
+    case "template":
+        if *genTemplate == "" {
+            return cfg, fmt.Errorf("-gen template needs -gen-template")
+        }
+        src, err := processor.NewTemplateSource(*genTemplate)
+        if err != nil {
+            return cfg, fmt.Errorf("invalid -gen-template: %w", err)
+        }
+        cfg.genTemplate = src
+    default:
+        return cfg, fmt.Errorf("unknown -gen %q (choose numbered, random, skewed, words or template)", cfg.gen)
+    }
+    if cfg.genLength <= 0 {
+        return cfg, fmt.Errorf("-gen-length must be a positive integer, got %d", cfg.genLength)
+    }
+    if cfg.sampleRate <= 0 {
+        return cfg, fmt.Errorf("-sample-rate must be a positive integer, got %d", cfg.sampleRate)
+    }
+    if cfg.sampleRate > 1 && (cfg.splitOutput || cfg.mode == "wordfreq") {
+        return cfg, fmt.Errorf("-sample-rate cannot be combined with -split-output or -mode wordfreq")
+    }
+    if cfg.limit < 0 {
+        return cfg, fmt.Errorf("-limit must not be negative, got %d", cfg.limit)
+    }
+    if cfg.repeat <= 0 {
+        return cfg, fmt.Errorf("-repeat must be a positive integer, got %d", cfg.repeat)
+    }
+    // The copies made by -repeat carry the same data, so they could
+    // not both keep their content IDs.
+    if cfg.repeat > 1 && cfg.idMode == "content" {
+        return cfg, fmt.Errorf("-repeat cannot be combined with -id-mode content")
+    }
+    if cfg.maxDataLen < 0 {
+        return cfg, fmt.Errorf("-max-data-len must not be negative, got %d", cfg.maxDataLen)
+    }
+    if cfg.dispatch != "shared" && cfg.dispatch != "roundrobin" {
+        return cfg, fmt.Errorf("unknown -dispatch %q (choose shared or roundrobin)", cfg.dispatch)
+    }
+    if cfg.dispatch == "roundrobin" && (cfg.maxWorkers > 0 || cfg.batchSize > 0 || cfg.idleTimeout > 0) {
+        return cfg, fmt.Errorf("-dispatch roundrobin cannot be combined with -max-workers, -batch or -idle-timeout")
+    }
+    if cfg.onOversize != "truncate" && cfg.onOversize != "reject" {
+        return cfg, fmt.Errorf("unknown -on-oversize %q (choose truncate or reject)", cfg.onOversize)
+    }
+    if cfg.guarantee != "at-least-once" && cfg.guarantee != "exactly-once" {
+        return cfg, fmt.Errorf("unknown -guarantee %q (choose at-least-once or exactly-once)", cfg.guarantee)
+    }
+    if cfg.mode != "process" && cfg.mode != "wordfreq" {
+        return cfg, fmt.Errorf("unknown -mode %q (choose process or wordfreq)", cfg.mode)
+    }
+    if cfg.mode == "wordfreq" && (cfg.stream || cfg.checkpoint != "" || cfg.summary || cfg.batchSize > 0 || cfg.manifest != "" || cfg.checksum) {
+        return cfg, fmt.Errorf("-mode wordfreq cannot be combined with -stream, -checkpoint, -summary, -batch, -manifest or -checksum")
+    }
+    if cfg.topWords < 0 {
+        return cfg, fmt.Errorf("-top must not be negative, got %d", cfg.topWords)
+    }
+    switch cfg.sortBy {
+    case "none":
+    case "id":
+        cfg.ordered = true
+    case "length", "input":
+        if cfg.ordered || cfg.stream || cfg.splitOutput || cfg.mode == "wordfreq" {
+            return cfg, fmt.Errorf("-sort %s cannot be combined with -ordered, -stream, -split-output or -mode wordfreq", cfg.sortBy)
+        }
+    default:
+        return cfg, fmt.Errorf("unknown -sort %q (choose id, length, input or none)", cfg.sortBy)
+    }
+    if cfg.stream && cfg.ordered {
+        return cfg, fmt.Errorf("-stream cannot be combined with -ordered")
+    }
+    if cfg.splitOutput && (cfg.stream || cfg.ordered || cfg.summary || cfg.mode == "wordfreq" || cfg.outputFile == "-") {
+        return cfg, fmt.Errorf("-split-output cannot be combined with -stream, -ordered, -summary, -mode wordfreq or -output -")
+    }
+    if cfg.checkpoint != "" && !cfg.stream {
+        // The stream writer checkpoints IDs once their results are on
+        // disk; collected in memory, they would be marked before they
+        // were written and lost in a crash.
+        return cfg, fmt.Errorf("-checkpoint needs -stream")
+    }
+    if cfg.resume {
+        if cfg.checkpoint == "" {
+            return cfg, fmt.Errorf("-resume needs -checkpoint")
+        }
+        if cfg.format == "json" {
+            return cfg, fmt.Errorf("-resume cannot append to -format json output")
+        }
+    }
+    if cfg.appendOut {
+        for _, out := range cfg.outputs {
+            if out.format == "json" {
+                return cfg, fmt.Errorf("-append cannot be used with -format json: a JSON array cannot be appended to")
+            }
+        }
+        if cfg.mode == "wordfreq" {
+            return cfg, fmt.Errorf("-append cannot be combined with -mode wordfreq")
+        }
+    }
+    // The count read back would include the results already in an
+    // appended-to file.
+    if cfg.validateOut && (cfg.appendOut || cfg.resume || cfg.mode == "wordfreq") {
+        return cfg, fmt.Errorf("-validate-output cannot be combined with -append, -resume or -mode wordfreq")
+    }
+    if cfg.summary && len(summaryFiles(cfg)) == 0 {
+        return cfg, fmt.Errorf("-summary needs a text -output file")
+    }
+
+    return cfg, nil
+}
+
+func main() {
+    startedAt := time.Now()
+
+    // Configuration
+    cfg, err := parseConfig()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+        flag.Usage()
+        os.Exit(exitUsage)
+    }
+    numWorkers := cfg.numWorkers
+
+    color := !cfg.noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr)
+    logger := newLogger(cfg.logFormat, cfg.logLevel, color)
+    logger.Info("starting Data Processing System in Go")
+
+    // Load tasks from the input file if one was given, stream them
+    // from standard input for "-input -" or a pipe, otherwise generate
+    // synthetic ones.
+    var timing phaseTimes
+    loadStart := time.Now()
+    var taskList []processor.Task
+    var loadStats processor.LoadStats
+    loadOpts := processor.LoadOptions{
+        Format:     cfg.inputFormat,
+        Column:     cfg.column,
+        IDColumn:   cfg.idColumn,
+        TagColumns: cfg.tagColumns.values,
+        NoHeader:   cfg.noHeader,
+        Strict:     cfg.strict,
+        Priorities: cfg.priorities,
+        Dedupe:     cfg.dedupe,
+        IDMode:     cfg.idMode,
+        Limit:      cfg.limit,
+        Logger:     logger,
+        Stats:      &loadStats,
+    }
+    inputs := cfg.inputFiles.values
+    fromStdin := (len(inputs) == 1 && inputs[0] == "-") || (len(inputs) == 0 && cfg.inputDir == "" && !cfg.tasksSet && stdinIsPiped())
+    if fromStdin {
+        logger.Info("streaming tasks from standard input")
+    } else if cfg.inputDir != "" {
+        taskList, err = processor.LoadTaskDir(cfg.inputDir, cfg.recursive, loadOpts)
+        if err != nil {
+            logger.Error("loading tasks failed", "error", err)
+            os.Exit(exitError)
+        }
+        logger.Info("loaded tasks", "count", len(taskList), "input_dir", cfg.inputDir)
+        logLoadStats(logger, cfg, loadStats)
+    } else if len(inputs) > 0 {
+        taskList, err = processor.LoadTaskFiles(inputs, loadOpts)
+        if err != nil {
+            logger.Error("loading tasks failed", "error", err)
+            os.Exit(exitError)
+        }
+        logger.Info("loaded tasks", "count", len(taskList), "input", cfg.inputFiles.String())
+        logLoadStats(logger, cfg, loadStats)
+    } else {
+        source, err := taskSource(cfg)
+        if err != nil {
+            logger.Error("loading -gen-dict failed", "error", err)
+            os.Exit(exitError)
+        }
+        n := cfg.numTasks
+        if cfg.limit > 0 && cfg.limit < n {
+            n, loadStats.LimitReached = cfg.limit, true
+        }
+        taskList = processor.GenerateFrom(source, n)
+        if cfg.idMode == "content" {
+            processor.AssignContentIDs(taskList)
+        }
+    }
+    if cfg.repeat > 1 {
+        if fromStdin {
+            logger.Error("-repeat cannot be used with tasks streamed from standard input")
+            os.Exit(exitUsage)
+        }
+        taskList = processor.RepeatTasks(taskList, cfg.repeat)
+        logger.Info("repeated tasks", "repeat", cfg.repeat, "count", len(taskList))
+        if cfg.limit > 0 && len(taskList) > cfg.limit {
+            taskList, loadStats.LimitReached = taskList[:cfg.limit], true
+        }
+    }
+    if cfg.shuffle {
+        if fromStdin {
+            logger.Error("-shuffle cannot be used with tasks streamed from standard input")
+            os.Exit(exitUsage)
+        }
+        processor.ShuffleTasks(taskList, cfg.seed)
+        logger.Info("shuffled tasks", "seed", cfg.seed)
+    }
+    numTasks := len(taskList)
+
+    if cfg.dryRun {
+        // A preflight check needs the count, so standard input is read
+        // in full here rather than streamed.
+        if fromStdin {
+            taskList, err = processor.ReadTasks(os.Stdin, loadOpts)
+            if err != nil {
+                logger.Error("reading standard input failed", "error", err)
+                os.Exit(exitError)
+            }
+            logLoadStats(logger, cfg, loadStats)
+        }
+        printDryRun(taskList)
+        return
+    }
+
+    timing.load = time.Since(loadStart)
+
+    var tasksLog any = numTasks
+    if fromStdin {
+        tasksLog = "streaming"
+    }
+    if len(cfg.stages) == 0 {
+        logger.Info("configured pool", "workers", numWorkers, "workers_from", cfg.workersFrom, "tasks", tasksLog)
+    }
+    for i, st := range cfg.stages {
+        logger.Info("configured stage", "stage", i+1, "transform", st.pipeline.String(), "workers", st.workers, "workers_from", st.workersFrom, "tasks", tasksLog)
+    }
+
+    // Context used to cancel workers and the producer early,
+    // optionally bounded by -timeout.
+    var ctx context.Context
+    var cancel context.CancelFunc
+    if cfg.timeout > 0 {
+        ctx, cancel = context.WithTimeout(context.Background(), cfg.timeout)
+    } else {
+        ctx, cancel = context.WithCancel(context.Background())
+    }
+    defer cancel()
+
+    // Ctrl-C / SIGTERM take the same cancellation path so that
+    // collected results are still written.
+    handleSignals(cancel, logger)
+
+    // Standard input is read as the pool consumes it rather than up
+    // front, so arbitrarily long pipelines stay in constant memory.
+    var stream <-chan processor.Task
+    streamErr := func() error { return nil }
+    if fromStdin {
+        stream, streamErr = processor.StreamTasks(ctx, os.Stdin, loadOpts)
+    }
+
+    // Run the worker pool. Tasks are dispatched in order over the
+    // task channel; see processor.Config for the buffering semantics.
+    logger.Info("random seed", "seed", cfg.seed)
+
+    // Progress line on standard error every 500ms, plus a final one
+    // once the run is over.
+    progress := processor.NewProgress(numTasks)
+    stopProgress := make(chan struct{})
+    progressDone := make(chan struct{})
+    if cfg.quiet {
+        close(progressDone)
+    } else {
+        go progress.Report(os.Stderr, progressInterval, stopProgress, progressDone)
+    }
+
+    if cfg.mode == "wordfreq" {
+        code := runWordFrequency(ctx, cfg, taskList, stream, progress, logger)
+        close(stopProgress)
+        <-progressDone
+        if err := streamErr(); err != nil {
+            logger.Error("reading standard input failed", "error", err)
+            code = exitError
+        }
+        if code != 0 {
+            os.Exit(code)
+        }
+        return
+    }
+
+    // With -checkpoint, completed task IDs are saved as the run goes;
+    // -resume first loads the ones a previous run already finished.
+    // The stream writer marks each ID once its result is flushed to
+    // the output, so the checkpoint never gets ahead of the file.
+    var checkpoint *processor.Checkpoint
+    if cfg.checkpoint != "" {
+        checkpoint, err = processor.OpenCheckpoint(cfg.checkpoint, cfg.resume)
+        if err != nil {
+            logger.Error("opening checkpoint failed", "error", err)
+            os.Exit(exitError)
+        }
+        if cfg.resume {
+            logger.Info("resuming from checkpoint", "path", cfg.checkpoint, "done", checkpoint.Len())
+        }
+    }
+
+    // With -stream, a writer goroutine writes results to the output as
+    // they complete, rather than holding them all until the end.
+    writer := newResultWriter(cfg)
+    var resultsCh chan processor.Result
+    var written int
+    var writeErr error
+    writeDone := make(chan struct{})
+    if cfg.stream {
+        resultsCh = make(chan processor.Result)
+        logger.Info("streaming results", "format", cfg.format, "destination", fmt.Sprint(writer))
+        // os.Stdout is unbuffered and streaming flushes after every
+        // result, so a -tee copy appears line by line.
+        var tee io.Writer
+        if cfg.tee {
+            tee = os.Stdout
+        }
+        go func() {
+            defer close(writeDone)
+            switch {
+            case cfg.outputFile == "-":
+                out := stdoutWriter(cfg, cfg.format)
+                out.OnWritten = markDone(checkpoint)
+                written, writeErr = out.WriteStream(resultsCh)
+            default:
+                out := fileWriter(cfg, cfg.outputFile, cfg.format)
+                out.Append = cfg.resume || cfg.appendOut
+                out.OnWritten = markDone(checkpoint)
+                written, writeErr = out.WriteStreamTee(tee, resultsCh)
+            }
+        }()
+    } else {
+        close(writeDone)
+    }
+
+    // With -metrics-addr, live counters are served over HTTP until the
+    // run is over.
+    var metrics *processor.Metrics
+    var stopMetrics func()
+    if cfg.metricsAddr != "" {
+        metrics = new(processor.Metrics)
+        stopMetrics, err = serveMetrics(cfg.metricsAddr, metrics, logger)
+        if err != nil {
+            logger.Error("starting metrics server failed", "error", err)
+            os.Exit(exitError)
+        }
+    }
+
+    // With -stats, sample the heap while the pool runs to find its peak.
+    var heap *heapSampler
+    if cfg.memStats {
+        heap = startHeapSampler(memSampleInterval)
+    }
+
+    // With -split-output, each worker writes its own file named after
+    // -output.
+    var splitOutput *processor.FileWriter
+    if cfg.splitOutput {
+        out := fileWriter(cfg, cfg.outputFile, cfg.format)
+        out.Append = cfg.appendOut
+        splitOutput = &out
+    }
+
+    // SIGUSR1 writes the results collected so far to a snapshot file
+    // while the run carries on.
+    var snapshots chan chan<- []processor.Result
+    if !cfg.stream && !cfg.splitOutput {
+        snapshots = make(chan chan<- []processor.Result)
+    }
+    stopSnapshots := handleSnapshots(cfg, snapshots, logger)
+
+    // SIGUSR2 pauses the workers, and the next one resumes them.
+    var pauses chan bool
+    if len(pauseSignals) > 0 {
+        pauses = make(chan bool)
+    }
+    stopPause := handlePause(pauses, logger)
+
+    runConfig := processor.Config{
+        Context:          ctx,
+        Tasks:            taskList,
+        Stream:           stream,
+        Workers:          numWorkers,
+        BufferSize:       cfg.bufferSize,
+        Transform:        cfg.pipeline.Transform(),
+        TransformName:    transformName(cfg),
+        FanOut:           processor.FanOuts[cfg.fanOut],
+        Decode:           processor.Decoders[cfg.decode],
+        MaxRetries:       cfg.maxRetries,
+        Backoff:          cfg.backoff,
+        TaskTimeout:      cfg.taskTimeout,
+        IdleTimeout:      cfg.idleTimeout,
+        Budget:           cfg.budget,
+        Drain:            cfg.drain,
+        BatchSize:        cfg.batchSize,
+        MaxWorkers:       cfg.maxWorkers,
+        MaxInFlight:      cfg.maxInFlight,
+        ScaleIdleTimeout: cfg.scaleIdle,
+        Rate:             cfg.rate,
+        Seed:             cfg.seed,
+        NoDelay:          !cfg.delay,
+        Checksums:        cfg.checksum,
+        Ordered:          cfg.ordered,
+        Sequential:       cfg.sequential,
+        FailFast:         cfg.failFast,
+        MaxFailures:      cfg.maxFailures,
+        MaxFailureRate:   cfg.maxFailRate / 100,
+        ExactlyOnce:      cfg.guarantee == "exactly-once",
+        MaxDataLen:       cfg.maxDataLen,
+        OnOversize:       cfg.onOversize,
+        Dispatch:         cfg.dispatch,
+        SampleRate:       cfg.sampleRate,
+        SplitOutput:      splitOutput,
+        Filter:           cfg.filter,
+        Checkpoint:       checkpoint,
+        Results:          resultsCh,
+        Progress:         progress,
+        Metrics:          metrics,
+        Snapshot:         snapshots,
+        Pause:            pauses,
+        Logger:           logger,
+    }
+    // With -stage, each stage's results feed the next stage's workers;
+    // the reports are merged for the summary and outputs.
+    processStart := time.Now()
+    var report *processor.Report
+    var stageReports []*processor.Report
+    if len(cfg.stages) > 0 {
+        stageReports, err = processor.RunStages(runConfig, pipelineStages(cfg)...)
+        report = mergeStageReports(stageReports)
+    } else {
+        report, err = processor.RunReport(runConfig)
+    }
+    timing.process = time.Since(processStart)
+    stopSnapshots()
+    stopPause()
+    if stopMetrics != nil {
+        stopMetrics()
+    }
+    close(stopProgress)
+    <-progressDone
+    <-writeDone
+    // The pool's last save may come before the writer has marked the
+    // final results.
+    if checkpoint != nil {
+        if err := checkpoint.Save(); err != nil {
+            logger.Error("saving checkpoint failed", "path", cfg.checkpoint, "error", err)
+        }
+    }
+    if report == nil {
+        logger.Error("running processor failed", "error", err)
+        os.Exit(exitError)
+    }
+    results, failures := report.Results, report.Failures
+    // After an idle shutdown standard input is still open, and its
+    // reader may still be waiting on it, so there is no error to check.
+    var inputErr error
+    if report.Idle {
+        logger.Info("stopped after the workers went idle", "idle_timeout", cfg.idleTimeout)
+    } else {
+        inputErr = streamErr()
+    }
+    if inputErr != nil {
+        logger.Error("reading standard input failed", "error", inputErr)
+    }
+    if fromStdin {
+        logLoadStats(logger, cfg, loadStats)
+    }
+
+    if stageReports == nil {
+        printWorkerStats("Worker statistics:", report.Stats)
+    }
+    for i, r := range stageReports {
+        printWorkerStats(fmt.Sprintf("Stage %d (%s) worker statistics:", i+1, cfg.stages[i].pipeline), r.Stats)
+    }
+    fmt.Print(report.Summary)
+    if !cfg.sequential {
+        fmt.Printf("  producer blocked %v waiting for workers\n", report.ProducerBlocked.Round(time.Millisecond))
+    }
+    if cfg.sampleRate > 1 {
+        kept := len(results)
+        if cfg.stream {
+            kept = written
+        }
+        fmt.Printf("  sampled 1 in %d tasks: %d of %d results kept for the output\n", cfg.sampleRate, kept, report.Summary.Tasks)
+    }
+    if loadStats.LimitReached {
+        fmt.Printf("  limited to the first %d tasks by -limit\n", cfg.limit)
+    }
+    if report.BudgetSpent {
+        if fromStdin {
+            fmt.Printf("  budget %v spent: %d tasks completed\n", cfg.budget, report.Summary.Tasks)
+        } else {
+            fmt.Printf("  budget %v spent: %d of %d tasks completed\n", cfg.budget, report.Summary.Tasks, numTasks)
+        }
+    }
+    if report.Drained {
+        fmt.Printf("  drained after shutdown: %d tasks completed\n", report.Summary.Tasks)
+    }
+    if latency := report.Summary.Latency(); latency.Count > 0 {
+        printLatency(latency)
+    }
+    if heap != nil {
+        printMemStats(heap.stop(), report.Summary.Tasks+len(failures))
+    }
+
+    for _, f := range failures {
+        logger.Error("task failed after retries",
+            "worker_id", f.WorkerID, "task_id", f.Task.ID, "kind", processor.KindName(f.Kind()), "attempts", f.Attempts, "error", f.Err)
+    }
+
+    if report.Drained {
+        logger.Warn("processing stopped early after draining the queue, writing collected results", "error", err, "results", len(results))
+    } else if processor.IsCancelled(err) {
+        logger.Warn("processing stopped early, writing collected results", "error", err, "results", len(results))
+    } else if errors.Is(err, processor.ErrFailFast) {
+        logger.Error("processing stopped at first failure, writing collected results", "error", err, "results", len(results))
+    } else if errors.Is(err, processor.ErrTooManyFailures) {
+        logger.Error("processing aborted after too many failures, writing collected results", "error", err, "failed", len(failures), "results", len(results))
+    }
+
+    // Write results to the chosen sink, unless they were streamed or
+    // the workers wrote their own files
+    writeStart := time.Now()
+    switch {
+    case cfg.splitOutput:
+        writeErr = printOutputFiles(report.Files)
+        for _, f := range report.Files {
+            written += f.Results
+        }
+    case !cfg.stream:
+        switch cfg.sortBy {
+        case "length":
+            processor.SortResultsByLength(results)
+        case "input":
+            processor.SortResultsByInput(results)
+        }
+        logger.Info("writing results", "format", outputFormats(cfg), "destination", fmt.Sprint(writer))
+        writeErr = writer.Write(results)
+        written = len(results)
+    }
+    exitCode := 0
+    if inputErr != nil {
+        exitCode = exitError
+    }
+    if writeErr != nil {
+        logger.Error("writing results failed", "error", writeErr)
+        exitCode = exitError
+    } else {
+        logger.Info("results successfully written", "destination", fmt.Sprint(writer), "count", written)
+        // Validated before -summary adds to the text files.
+        if cfg.validateOut && !validateOutputs(cfg, report.Files, written, logger) {
+            exitCode = exitError
+        }
+        for _, path := range summaryFiles(cfg) {
+            if err := processor.AppendSummary(path, report.Summary); err != nil {
+                logger.Error("appending summary failed", "path", path, "error", err)
+                exitCode = exitError
+            }
+        }
+        // The checksum covers each file as finally written, summary
+        // included.
+        if cfg.checksum {
+            for _, path := range outputFiles(cfg, report.Files) {
+                sum, err := processor.WriteChecksumFile(path)
+                if err != nil {
+                    logger.Error("writing checksum failed", "path", path, "error", err)
+                    exitCode = exitError
+                    continue
+                }
+                logger.Info("checksum written", "path", path+processor.ChecksumSuffix, "sha256", sum)
+            }
+        }
+    }
+
+    if cfg.deadLetter != "" {
+        failed := make([]processor.Task, 0, len(failures))
+        for _, f := range failures {
+            failed = append(failed, f.Task)
+        }
+        logger.Info("writing dead-letter file", "path", cfg.deadLetter, "count", len(failed))
+        if err := fileWriter(cfg, cfg.deadLetter, "").WriteFailedTasks(failed); err != nil {
+            logger.Error("writing dead-letter file failed", "error", err)
+            exitCode = exitError
+        }
+    }
+
+    timing.write = time.Since(writeStart)
+    timing.total = time.Since(startedAt)
+    printTiming(timing, fromStdin, cfg.stream)
+
+    // A clean run leaves nothing to resume; one cut short by -budget
+    // does.
+    if checkpoint != nil && err == nil && !report.BudgetSpent && writeErr == nil && inputErr == nil && len(failures) == 0 {
+        if err := checkpoint.Remove(); err != nil {
+            logger.Error("removing checkpoint failed", "error", err)
+        }
+    }
+
+    if exitCode == 0 && len(failures) > 0 {
+        exitCode = exitTaskFailures
+    }
+
+    if cfg.manifest != "" {
+        m := newManifest(cfg, startedAt, report)
+        m.ExitCode = exitCode
+        if err := writeManifest(cfg.manifest, m); err != nil {
+            logger.Error("writing manifest failed", "path", cfg.manifest, "error", err)
+            exitCode = exitError
+        } else {
+            logger.Info("manifest written", "path", cfg.manifest)
+        }
+    }
+    logger.Info("Go Data Processing System finished", "exit_code", exitCode)
+    if exitCode != 0 {
+        os.Exit(exitCode)
+    }
+}
+
+// runWordFrequency runs -mode wordfreq: it counts the words across all
+// tasks and writes the -top most frequent to the output. It returns
+// the process exit code.
+func runWordFrequency(ctx context.Context, cfg config, taskList []processor.Task, stream <-chan processor.Task, progress *processor.Progress, logger *slog.Logger) int {
+    counts, err := processor.WordFrequency(processor.Config{
+        Context:    ctx,
+        Tasks:      taskList,
+        Stream:     stream,
+        Workers:    cfg.numWorkers,
+        BufferSize: cfg.bufferSize,
+        Rate:       cfg.rate,
+        Filter:     cfg.filter,
+        Progress:   progress,
+        Logger:     logger,
+    })
+    if counts == nil {
+        logger.Error("counting words failed", "error", err)
+        return exitError
+    }
+    if processor.IsCancelled(err) {
+        logger.Warn("counting stopped early, writing partial counts", "error", err)
+    }
+
+    top := processor.TopWords(counts, cfg.topWords)
+    logger.Info("writing word frequencies", "distinct_words", len(counts), "written", len(top), "destination", cfg.outputFile)
+    if cfg.outputFile == "-" {
+        err = processor.EncodeWordCounts(os.Stdout, cfg.format, top)
+    } else {
+        err = fileWriter(cfg, cfg.outputFile, cfg.format).WriteWordCounts(top)
+    }
+    if err != nil {
+        logger.Error("writing word frequencies failed", "error", err)
+        return exitError
+    }
+    return 0
+}
+
+// applyConfigFile sets flags from a JSON object mapping flag names to
+// values. Strings, numbers and booleans are passed to the flag as
+// written on the command line (durations as strings such as "5s"); an
+// array sets a repeatable flag once per element. Flags already given on
+// the command line are left alone, so they override the file.
+func applyConfigFile(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    var values map[string]json.RawMessage
+    if err := json.Unmarshal(data, &values); err != nil {
+        return err
+    }
+
+    onCommandLine := make(map[string]bool)
+    flag.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+    names := make([]string, 0, len(values))
+    for name := range values {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        if name == "config" || flag.Lookup(name) == nil {
+            return fmt.Errorf("unknown setting %q", name)
+        }
+        if onCommandLine[name] {
+            continue
+        }
+
+        var elems []json.RawMessage
+        if err := json.Unmarshal(values[name], &elems); err != nil {
+            elems = []json.RawMessage{values[name]}
+        }
+        for _, elem := range elems {
+            value, err := configValue(elem)
+            if err != nil {
+                return fmt.Errorf("%s: %w", name, err)
+            }
+            if err := flag.Set(name, value); err != nil {
+                return fmt.Errorf("%s: invalid value %q: %w", name, value, err)
+            }
+        }
+    }
+    return nil
+}
+
+// envPrefix starts the name of every environment variable read by
+// applyEnv.
+const envPrefix = "DPS_"
+
+// applyEnv sets flags from environment variables named after them,
+// envPrefix followed by the flag name in upper case with dashes as
+// underscores: DPS_WORKERS for -workers, DPS_MAX_RETRIES for
+// -max-retries. A repeatable flag is set once, so DPS_INPUT takes a
+// comma-separated list as -input does. Empty variables and flags given
+// on the command line are left alone.
+func applyEnv(environ []string) error {
+    onCommandLine := make(map[string]bool)
+    flag.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+    vars := make(map[string]string)
+    for _, kv := range environ {
+        if name, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, envPrefix) {
+            vars[name] = value
+        }
+    }
+    var err error
+    flag.VisitAll(func(f *flag.Flag) {
+        name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+        value := vars[name]
+        if err != nil || value == "" || onCommandLine[f.Name] {
+            return
+        }
+        if setErr := flag.Set(f.Name, value); setErr != nil {
+            err = fmt.Errorf("%s: invalid value %q: %w", name, value, setErr)
+        }
+    })
+    return err
+}
+
+// configValue renders one JSON scalar as a flag value string.
+func configValue(raw json.RawMessage) (string, error) {
+    decoder := json.NewDecoder(bytes.NewReader(raw))
+    decoder.UseNumber()
+    var v any
+    if err := decoder.Decode(&v); err != nil {
+        return "", err
+    }
+    switch v := v.(type) {
+    case string:
+        return v, nil
+    case json.Number:
+        return v.String(), nil
+    case bool:
+        return strconv.FormatBool(v), nil
+    }
+    return "", fmt.Errorf("unsupported value %s", raw)
+}
+
+// taskSource returns the generator selected by -gen. The random ones
+// are seeded from -seed, so a run with a fixed seed generates the same
+// tasks every time.
+func taskSource(cfg config) (processor.TaskSource, error) {
+    rng := rand.New(rand.NewSource(cfg.seed))
+    switch cfg.gen {
+    case "random":
+        return processor.RandomSource{Length: cfg.genLength, Rand: rng}, nil
+    case "skewed":
+        return processor.SkewedSource{Short: cfg.genLength, Long: cfg.genLong, LongShare: cfg.genShare, Rand: rng}, nil
+    case "words":
+        words, err := processor.ReadWords(cfg.genDict)
+        if err != nil {
+            return nil, err
+        }
+        return processor.WordSource{Words: words, Count: cfg.genLength, Rand: rng}, nil
+    case "template":
+        return cfg.genTemplate, nil
+    }
+    return processor.NumberedSource{}, nil
+}
+
+// logLoadStats reports how many input lines -dedupe dropped and how
+// many invalid jsonl lines or csv rows, or unreadable -input-dir files,
+// were skipped.
+func logLoadStats(logger *slog.Logger, cfg config, stats processor.LoadStats) {
+    if stats.LimitReached {
+        logger.Info("stopped loading at -limit", "limit", cfg.limit)
+    }
+    if cfg.dedupe {
+        logger.Info("dropped duplicate tasks", "duplicates", stats.Duplicates)
+    }
+    if stats.Invalid > 0 {
+        logger.Warn("skipped invalid input lines", "count", stats.Invalid)
+    }
+    if stats.Unreadable > 0 {
+        logger.Warn("skipped unreadable input files", "count", stats.Unreadable)
+    }
+}
+
+// stdinIsPiped reports whether standard input is a pipe or file
+// rather than an interactive terminal, so that "producer | go run ."
+// reads tasks without needing -input -. It is only asked when no task
+// source was given, since under cron or CI standard input is often
+// redirected without meaning to provide tasks.
+func stdinIsPiped() bool {
+    info, err := os.Stdin.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice == 0
+}
+
+// newLogger builds the structured logger used by main and the pool,
+// dropping records below level. Per-task messages are logged at debug,
+// lifecycle events at info. Logs go to standard error so that standard
+// output stays free for results (-output -) and the summary tables.
+// With color, the text format is colored by colorHandler.
+func newLogger(format string, level slog.Level, color bool) *slog.Logger {
+    opts := &slog.HandlerOptions{Level: level}
+    if format == "json" {
+        return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+    }
+    if color {
+        w := &colorWriter{w: os.Stderr}
+        return slog.New(colorHandler{slog.NewTextHandler(w, opts), w})
+    }
+    return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// ANSI escape sequences for the colored text log.
+const (
+    ansiReset  = "\x1b[0m"
+    ansiRed    = "\x1b[31m"
+    ansiGreen  = "\x1b[32m"
+    ansiYellow = "\x1b[33m"
+    ansiCyan   = "\x1b[36m"
+)
+
+// messageColors colors the records that mark a step of the run; other
+// records are colored by level, or not at all.
+var messageColors = map[string]string{
+    "worker started":               ansiCyan,
+    "task processed":               ansiGreen,
+    "results successfully written": ansiGreen,
+}
+
+// colorHandler wraps the text log handler to color each record: errors
+// red, warnings yellow, and the messages in messageColors as listed.
+// Only the log is colored; results and the summary never are.
+type colorHandler struct {
+    slog.Handler
+    w *colorWriter
+}
+
+func (h colorHandler) Handle(ctx context.Context, r slog.Record) error {
+    color := messageColors[r.Message]
+    switch {
+    case r.Level >= slog.LevelError:
+        color = ansiRed
+    case r.Level >= slog.LevelWarn:
+        color = ansiYellow
+    }
+    h.w.mu.Lock()
+    defer h.w.mu.Unlock()
+    h.w.color = color
+    return h.Handler.Handle(ctx, r)
+}
+
+func (h colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return colorHandler{h.Handler.WithAttrs(attrs), h.w}
+}
+
+func (h colorHandler) WithGroup(name string) slog.Handler {
+    return colorHandler{h.Handler.WithGroup(name), h.w}
+}
+
+// colorWriter writes each log line wrapped in the color colorHandler
+// picked for it. The text handler writes a record in a single Write
+// call, ending in a newline.
+type colorWriter struct {
+    mu    sync.Mutex
+    w     io.Writer
+    color string
+}
+
+func (c *colorWriter) Write(p []byte) (int, error) {
+    if c.color == "" {
+        return c.w.Write(p)
+    }
+    line := bytes.TrimSuffix(p, []byte("\n"))
+    if _, err := io.WriteString(c.w, c.color+string(line)+ansiReset+"\n"); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
+// isTerminal reports whether f is an interactive terminal rather than
+// a pipe or file.
+func isTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice != 0
+}
+
+// newResultWriter picks the output sink for the run: standard output
+// when -output is "-", otherwise the named file, or all of them when
+// several formats were asked for.
+func newResultWriter(cfg config) processor.ResultWriter {
+    writers := make(processor.MultiWriter, 0, len(cfg.outputs))
+    for _, out := range cfg.outputs {
+        if out.path == "-" {
+            writers = append(writers, stdoutWriter(cfg, out.format))
+        } else {
+            w := fileWriter(cfg, out.path, out.format)
+            w.Append = cfg.appendOut
+            writers = append(writers, w)
+        }
+    }
+    if len(writers) == 1 {
+        return writers[0]
+    }
+    return writers
+}
+
+// fileWriter returns the FileWriter for path in format, with the run's
+// -template, -delimiter, -deterministic and -no-atomic.
+func fileWriter(cfg config, path, format string) processor.FileWriter {
+    return processor.FileWriter{Path: path, Format: format, Template: cfg.template, Delimiter: processor.Delimiters[cfg.delimiter], Deterministic: cfg.deterministic, InPlace: cfg.noAtomic}
+}
+
+// stdoutWriter is fileWriter for standard output.
+func stdoutWriter(cfg config, format string) processor.StdoutWriter {
+    return processor.StdoutWriter{Format: format, Template: cfg.template, Delimiter: processor.Delimiters[cfg.delimiter], Deterministic: cfg.deterministic}
+}
+
+// markDone returns an OnWritten hook marking each written result's
+// task in checkpoint, or nil without one.
+func markDone(checkpoint *processor.Checkpoint) func(processor.Result) {
+    if checkpoint == nil {
+        return nil
+    }
+    return func(r processor.Result) { checkpoint.Mark(r.TaskID) }
+}
+
+// outputFormats lists the output formats for log messages.
+func outputFormats(cfg config) string {
+    formats := make([]string, len(cfg.outputs))
+    for i, out := range cfg.outputs {
+        formats[i] = out.format
+    }
+    return strings.Join(formats, ",")
+}
+
+// transformName describes the -transform pipeline and -fan-out, if
+// any, for the results.
+func transformName(cfg config) string {
+    if cfg.fanOut == "" {
+        return cfg.pipeline.String()
+    }
+    return cfg.pipeline.String() + "|" + cfg.fanOut
+}
+
+// pipelineStages builds the processor stages for -stage. -fan-out
+// applies to the last one.
+func pipelineStages(cfg config) []processor.Stage {
+    stages := make([]processor.Stage, len(cfg.stages))
+    for i, st := range cfg.stages {
+        stages[i] = processor.Stage{
+            Workers:       st.workers,
+            Transform:     st.pipeline.Transform(),
+            TransformName: st.pipeline.String(),
+        }
+    }
+    last := &stages[len(stages)-1]
+    last.FanOut = processor.FanOuts[cfg.fanOut]
+    if cfg.fanOut != "" {
+        last.TransformName += "|" + cfg.fanOut
+    }
+    return stages
+}
+
+// mergeStageReports folds the reports of a -stage run into one: the
+// last stage's results, worker statistics and files, the failures of
+// every stage, and the first stage's view of the input. It returns nil
+// if the stages never ran.
+func mergeStageReports(reports []*processor.Report) *processor.Report {
+    if len(reports) == 0 {
+        return nil
+    }
+    first := reports[0]
+    merged := *reports[len(reports)-1]
+    merged.Failures = nil
+    merged.Summary.FailuresByKind = make(map[string]int)
+    for _, r := range reports {
+        merged.Failures = append(merged.Failures, r.Failures...)
+        for kind, n := range r.Summary.FailuresByKind {
+            merged.Summary.FailuresByKind[kind] += n
+        }
+    }
+    merged.Tasks = first.Tasks
+    merged.Summary.Filtered = first.Summary.Filtered
+    merged.Repeated = first.Repeated
+    merged.ProducerBlocked = first.ProducerBlocked
+    merged.Idle, merged.BudgetSpent = first.Idle, first.BudgetSpent
+    return &merged
+}
+
+// outputFiles returns the result files the run wrote: the -output
+// files other than standard output or, with -split-output, the
+// workers' files.
+func outputFiles(cfg config, split []processor.OutputFile) []string {
+    var paths []string
+    if cfg.splitOutput {
+        for _, f := range split {
+            paths = append(paths, f.Path)
+        }
+        return paths
+    }
+    for _, out := range cfg.outputs {
+        if out.path != "-" {
+            paths = append(paths, out.path)
+        }
+    }
+    return paths
+}
+
+// validateOutputs reads back each file written for the run, with the
+// FileWriter that wrote it so that -delimiter applies, expecting
+// written results in every -output file or the count each
+// -split-output worker wrote in its own. It logs every file that fails
+// and reports whether all passed.
+func validateOutputs(cfg config, split []processor.OutputFile, written int, logger *slog.Logger) bool {
+    type check struct {
+        path, format string
+        want         int
+    }
+    var checks []check
+    if cfg.splitOutput {
+        for _, f := range split {
+            checks = append(checks, check{f.Path, cfg.format, f.Results})
+        }
+    } else {
+        for _, out := range cfg.outputs {
+            if out.path != "-" {
+                checks = append(checks, check{out.path, out.format, written})
+            }
+        }
+    }
+    ok := true
+    for _, c := range checks {
+        if err := fileWriter(cfg, c.path, c.format).Validate(c.want); err != nil {
+            logger.Error("output validation failed", "path", c.path, "error", err)
+            ok = false
+            continue
+        }
+        logger.Info("output validated", "path", c.path, "results", c.want)
+    }
+    return ok
+}
+
+// summaryFiles returns the text output files -summary appends to.
+func summaryFiles(cfg config) []string {
+    if !cfg.summary {
+        return nil
+    }
+    var paths []string
+    for _, out := range cfg.outputs {
+        if out.format == "text" && out.path != "-" {
+            paths = append(paths, out.path)
+        }
+    }
+    return paths
+}
+
+// RunManifest is the -manifest record of a run, for telling later what
+// produced a set of results.
+type RunManifest struct {
+    // Flags holds the value of every flag as the run used it, after
+    // the config file and with the seed actually chosen.
+    Flags      map[string]string `json:"flags"`
+    StartedAt  time.Time         `json:"started_at"`
+    FinishedAt time.Time         `json:"finished_at"`
+    Tasks      int               `json:"tasks"`
+    Succeeded  int               `json:"succeeded"`
+    Failed     int               `json:"failed"`
+    Filtered   int               `json:"filtered"`
+    Outputs    []string          `json:"outputs"`
+    DeadLetter string            `json:"dead_letter,omitempty"`
+    ExitCode   int               `json:"exit_code"`
+}
+
+// newManifest fills in a RunManifest from the finished run. Outputs
+// are the -output paths or, with -split-output, the workers' files.
+func newManifest(cfg config, startedAt time.Time, report *processor.Report) RunManifest {
+    flags := make(map[string]string)
+    flag.VisitAll(func(f *flag.Flag) {
+        flags[f.Name] = f.Value.String()
+    })
+    flags["seed"] = strconv.FormatInt(cfg.seed, 10)
+    flags["workers"] = strconv.Itoa(cfg.numWorkers)
+
+    var outputs []string
+    if cfg.splitOutput {
+        for _, f := range report.Files {
+            outputs = append(outputs, f.Path)
+        }
+    } else {
+        for _, out := range cfg.outputs {
+            outputs = append(outputs, out.path)
+        }
+    }
+
+    failed := len(report.Failures)
+    return RunManifest{
+        Flags:      flags,
+        StartedAt:  startedAt,
+        FinishedAt: time.Now(),
+        Tasks:      report.Tasks,
+        Succeeded:  max(report.Tasks-failed, 0),
+        Failed:     failed,
+        Filtered:   report.Summary.Filtered,
+        Outputs:    outputs,
+        DeadLetter: cfg.deadLetter,
+    }
+}
+
+// writeManifest writes m to path as indented JSON.
+func writeManifest(path string, m RunManifest) error {
+    data, err := json.MarshalIndent(m, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, append(data, '\n'), 0o666)
+}
+
+// printOutputFiles lists the per-worker files written by -split-output
+// and returns their write errors, joined.
+func printOutputFiles(files []processor.OutputFile) error {
+    var errs []error
+    fmt.Println("Output files:")
+    for _, f := range files {
+        fmt.Printf("  %s (Worker-%d, %d results)\n", f.Path, f.WorkerID, f.Results)
+        errs = append(errs, f.Err)
+    }
+    return errors.Join(errs...)
+}
+
+// printDryRun reports what a run would do: how many tasks there are
+// and the first dryRunPreview of them.
+func printDryRun(taskList []processor.Task) {
+    fmt.Printf("Dry run: %d tasks would be processed\n", len(taskList))
+    for i, task := range taskList {
+        if i == dryRunPreview {
+            fmt.Printf("  ... and %d more\n", len(taskList)-dryRunPreview)
+            break
+        }
+        fmt.Printf("  Task-%d (priority %d): %q\n", task.ID, task.Priority, task.Data)
+    }
+}
+
+// serveMetrics starts an HTTP server on addr exposing metrics at
+// /metrics. The listener is opened before it returns, so a bad address
+// is reported straight away; the returned function shuts the server
+// down, waiting briefly for in-flight scrapes.
+func serveMetrics(addr string, metrics *processor.Metrics, logger *slog.Logger) (func(), error) {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", metrics)
+    server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+    go func() {
+        if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            logger.Error("metrics server failed", "error", err)
+        }
+    }()
+    logger.Info("serving metrics", "url", "http://"+listener.Addr().String()+"/metrics")
+
+    return func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+        if err := server.Shutdown(ctx); err != nil {
+            logger.Warn("metrics server shutdown", "error", err)
+        }
+    }, nil
+}
+
+// heapSampler tracks the peak live heap while a run is in progress,
+// since runtime.MemStats only reports the current HeapAlloc.
+type heapSampler struct {
+    peak uint64
+    quit chan struct{}
+    done chan struct{}
+}
+
+// startHeapSampler reads the heap size every interval until stop is
+// called.
+func startHeapSampler(interval time.Duration) *heapSampler {
+    h := &heapSampler{quit: make(chan struct{}), done: make(chan struct{})}
+    go func() {
+        defer close(h.done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        var m runtime.MemStats
+        for {
+            runtime.ReadMemStats(&m)
+            h.peak = max(h.peak, m.HeapAlloc)
+            select {
+            case <-ticker.C:
+            case <-h.quit:
+                return
+            }
+        }
+    }()
+    return h
+}
+
+// stop ends sampling and returns the final MemStats with the sampled
+// peak heap.
+func (h *heapSampler) stop() memStats {
+    close(h.quit)
+    <-h.done
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    return memStats{PeakHeap: max(h.peak, m.HeapAlloc), TotalAlloc: m.TotalAlloc, NumGC: m.NumGC}
+}
+
+// memStats is what -stats reports about a run's memory use.
+type memStats struct {
+    PeakHeap   uint64
+    TotalAlloc uint64
+    NumGC      uint32
+}
+
+// printMemStats prints the -stats table; tasks is the number of tasks
+// that finished, for the per-task allocation estimate.
+func printMemStats(m memStats, tasks int) {
+    const mib = 1 << 20
+    fmt.Println("Memory statistics:")
+    fmt.Printf("  peak heap:       %.2f MiB\n", float64(m.PeakHeap)/mib)
+    fmt.Printf("  total allocated: %.2f MiB\n", float64(m.TotalAlloc)/mib)
+    if tasks > 0 {
+        fmt.Printf("  per task:        %.1f KiB\n", float64(m.TotalAlloc)/float64(tasks)/1024)
+    }
+    fmt.Printf("  GC cycles:       %d\n", m.NumGC)
+}
+
+// printWorkerStats prints a per-worker summary table, under title,
+// showing how the tasks were distributed across the pool.
+func printWorkerStats(title string, stats []processor.WorkerStats) {
+    fmt.Println(title)
+    fmt.Printf("  %-10s %8s %12s %12s\n", "Worker", "Tasks", "Total delay", "Avg delay")
+    for _, s := range stats {
+        fmt.Printf("  %-10s %8d %12v %12v\n",
+            fmt.Sprintf("Worker-%d", s.WorkerID), s.TasksProcessed,
+            s.TotalDelay, s.AverageDelay().Round(time.Millisecond))
+    }
+}
+
+// phaseTimes is how long each phase of a run took, for printTiming.
+type phaseTimes struct {
+    load, process, write, total time.Duration
+}
+
+// printTiming prints the phase breakdown. Tasks streamed from standard
+// input are read during the process phase, and -stream writes results
+// during it, so those phases are noted as overlapping.
+func printTiming(t phaseTimes, fromStdin, stream bool) {
+    ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+    fmt.Printf("Timing: load=%.1fms process=%.1fms write=%.1fms total=%.3fs\n", ms(t.load), ms(t.process), ms(t.write), t.total.Seconds())
+    switch {
+    case fromStdin && stream:
+        fmt.Println("  (reading standard input and writing -stream results overlap the process phase)")
+    case fromStdin:
+        fmt.Println("  (reading standard input overlaps the process phase)")
+    case stream:
+        fmt.Println("  (writing -stream results overlaps the process phase)")
+    }
+}
+
+// printLatency prints the latency histogram and percentiles.
+func printLatency(stats processor.LatencyStats) {
+    fmt.Println("Latency (delay + processing):")
+    lower := 0.0
+    for _, b := range stats.Buckets {
+        label := fmt.Sprintf("%g-%gms", lower, b.UpperMS)
+        if math.IsInf(b.UpperMS, 1) {
+            label = fmt.Sprintf(">%gms", lower)
+        }
+        fmt.Printf("  %-12s %8d\n", label, b.Count)
+        lower = b.UpperMS
+    }
+    fmt.Printf("  p50 %.1fms  p90 %.1fms  p99 %.1fms\n", stats.P50, stats.P90, stats.P99)
+}
+
+// handleSnapshots writes the results collected so far, requested over
+// snapshots, to a timestamped file named after -output each time one of
+// the snapshotSignals arrives, until the returned function is called.
+// With a nil snapshots, as for -stream and -split-output where the
+// results are not kept, the signal is only logged.
+func handleSnapshots(cfg config, snapshots chan<- chan<- []processor.Result, logger *slog.Logger) (stop func()) {
+    if len(snapshotSignals) == 0 {
+        return func() {}
+    }
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, snapshotSignals...)
+    done := make(chan struct{})
+    finished := make(chan struct{})
+
+    base := cfg.outputFile
+    if base == "-" {
+        base = "go_results." + strings.Replace(cfg.format, "text", "txt", 1)
+    }
+    go func() {
+        defer close(finished)
+        for {
+            select {
+            case <-sigs:
+            case <-done:
+                return
+            }
+            if snapshots == nil {
+                logger.Warn("snapshots are not available with -stream or -split-output")
+                continue
+            }
+            reply := make(chan []processor.Result, 1)
+            select {
+            case snapshots <- reply:
+            case <-done:
+                return
+            }
+            // A request that races the end of the run may go
+            // unanswered; the final results follow anyway.
+            var results []processor.Result
+            select {
+            case results = <-reply:
+            case <-done:
+                return
+            }
+            path := processor.SnapshotPath(base, time.Now())
+            if err := fileWriter(cfg, path, cfg.format).Write(results); err != nil {
+                logger.Error("writing snapshot failed", "path", path, "error", err)
+                continue
+            }
+            logger.Info("snapshot written", "path", path, "results", len(results))
+        }
+    }()
+    return func() {
+        signal.Stop(sigs)
+        close(done)
+        <-finished
+    }
+}
+
+// handlePause sends alternately a pause and a resume request on pauses
+// for each of the pauseSignals that arrives, until the returned
+// function is called. It does nothing for a nil pauses.
+func handlePause(pauses chan<- bool, logger *slog.Logger) (stop func()) {
+    if pauses == nil {
+        return func() {}
+    }
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, pauseSignals...)
+    done := make(chan struct{})
+    finished := make(chan struct{})
+    go func() {
+        defer close(finished)
+        paused := false
+        for {
+            select {
+            case sig := <-sigs:
+                paused = !paused
+                logger.Info("received signal", "signal", sig, "pause", paused)
+                select {
+                case pauses <- paused:
+                case <-done:
+                    return
+                }
+            case <-done:
+                return
+            }
+        }
+    }()
+    return func() {
+        signal.Stop(sigs)
+        close(done)
+        <-finished
+    }
+}
+
+// handleSignals installs a handler for SIGINT and SIGTERM. The first
+// signal calls cancel, which stops the producer and the workers so
+// main can write the results collected so far. A second signal exits
+// immediately, so a hung run can always be killed.
+func handleSignals(cancel context.CancelFunc, logger *slog.Logger) {
+    sigs := make(chan os.Signal, 2)
+    signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+    go func() {
+        sig := <-sigs
+        logger.Warn("received signal, shutting down (send again to force exit)", "signal", sig)
+        cancel()
+
+        sig = <-sigs
+        logger.Error("received signal again, forcing exit", "signal", sig)
+        os.Exit(1)
+    }()
+}
@@ -0,0 +1,145 @@
+package processor
+
+import (
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strconv"
+)
+
+// The functions that write a whole file at once (WriteResultsToFile
+// and its JSON and CSV variants, FileWriter.Write without Append,
+// WriteWordCounts and WriteFailedTasks) write to a temporary file in
+// the same directory and rename it into place only once it is
+// complete, so that a reader never sees a partial file and a run killed
+// mid-write leaves any earlier file intact. A FileWriter with InPlace
+// set writes in place instead. Paths that exist but are not regular
+// files, such as /dev/null or a named pipe, are always written in
+// place, as are streamed, appended and split outputs.
+
+// atomicOutput is a file being written under a temporary name, moved
+// onto path when it is closed. gz, if set, compresses what is written
+// to tmp.
+type atomicOutput struct {
+    io.Writer
+    gz   *gzip.Writer
+    tmp  *os.File
+    path string
+}
+
+// createFile is createOutput for a file written in one go: unless
+// inPlace, a temporary file that Close renames onto path. A new file
+// gets the mode os.Create would give it, 0666 less the umask, and a
+// replaced one keeps its mode.
+func createFile(path string, inPlace bool) (io.WriteCloser, error) {
+    info, err := os.Stat(path)
+    if inPlace || (err == nil && !info.Mode().IsRegular()) {
+        return createOutput(path)
+    }
+
+    if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+        return nil, fmt.Errorf("cannot create the directory for %s: %w", path, err)
+    }
+    tmp, err := createTemp(path)
+    if err != nil {
+        return nil, err
+    }
+    if info != nil {
+        if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+            tmp.Close()
+            os.Remove(tmp.Name())
+            return nil, err
+        }
+    }
+    out := &atomicOutput{Writer: tmp, tmp: tmp, path: path}
+    if isGzip(path) {
+        out.gz = gzip.NewWriter(tmp)
+        out.Writer = out.gz
+    }
+    return out, nil
+}
+
+// createTemp creates a new file next to path with a random name, like
+// os.CreateTemp, but with mode 0666 before the umask rather than 0600.
+func createTemp(path string) (*os.File, error) {
+    dir, base := filepath.Split(path)
+    for try := 0; ; try++ {
+        name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+        file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+        if !errors.Is(err, fs.ErrExist) || try == 10000 {
+            return file, err
+        }
+    }
+}
+
+// Close finishes the temporary file, syncs it to disk and renames it
+// onto the path, then syncs the directory so that the rename survives
+// a crash too. If the file cannot be finished or renamed, it is
+// removed and the path left untouched.
+func (a *atomicOutput) Close() error {
+    err := a.finish()
+    if err == nil {
+        err = os.Rename(a.tmp.Name(), a.path)
+    }
+    if err != nil {
+        os.Remove(a.tmp.Name())
+        return err
+    }
+    return syncDir(filepath.Dir(a.path))
+}
+
+// finish ends the gzip stream, if any, and syncs and closes the
+// temporary file.
+func (a *atomicOutput) finish() error {
+    var err error
+    if a.gz != nil {
+        err = a.gz.Close()
+    }
+    if err == nil {
+        err = a.tmp.Sync()
+    }
+    if closeErr := a.tmp.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// syncDir flushes the entries of dir to disk. Windows cannot sync a
+// directory, and there it does nothing.
+func syncDir(dir string) error {
+    if runtime.GOOS == "windows" {
+        return nil
+    }
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    err = d.Sync()
+    if closeErr := d.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// abort closes and removes the temporary file, leaving the path as it
+// was.
+func (a *atomicOutput) abort() {
+    a.tmp.Close()
+    os.Remove(a.tmp.Name())
+}
+
+// discardFile closes a file from createFile after a failed write. An
+// atomic one is removed and never replaces its path.
+func discardFile(file io.WriteCloser) {
+    if a, ok := file.(*atomicOutput); ok {
+        a.abort()
+        return
+    }
+    file.Close()
+}
@@ -0,0 +1,45 @@
+package processor
+
+import (
+    "log/slog"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // scaleInterval is how often the autoscaler checks the backlog.
+    scaleInterval = 50 * time.Millisecond
+
+    // defaultScaleIdleTimeout is how long an autoscaled worker waits
+    // for a task before exiting, unless Config.ScaleIdleTimeout is set.
+    defaultScaleIdleTimeout = time.Second
+)
+
+// autoscale is the supervisor goroutine. Every scaleInterval it looks
+// at the backlog in the task queue's buffer and, while the buffer is
+// at least half full and fewer than maxWorkers workers are alive,
+// calls spawn to start one more. It returns, closing done, once stop
+// is closed.
+func autoscale(queue *ChannelQueue, maxWorkers int, active *atomic.Int32, spawn func(), stop <-chan struct{}, done chan<- struct{}, log *slog.Logger) {
+    defer close(done)
+    if log == nil {
+        log = discardLogger
+    }
+
+    ticker := time.NewTicker(scaleInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-stop:
+            return
+        case <-ticker.C:
+        }
+
+        backlog := queue.Len()
+        if backlog*2 >= queue.Cap() && int(active.Load()) < maxWorkers {
+            spawn()
+            log.Info("autoscaler added a worker", "backlog", backlog, "workers", active.Load())
+        }
+    }
+}
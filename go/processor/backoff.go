@@ -0,0 +1,74 @@
+package processor
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "time"
+)
+
+// defaultBackoffBase is the wait before a retry when Backoff.Base is 0.
+const defaultBackoffBase = 100 * time.Millisecond
+
+// Backoff is the policy for how long a worker waits between the
+// attempts at a failing task. Strategy is one of:
+//
+//   - "none": retry at once;
+//   - "fixed" (or empty): wait Base before every retry;
+//   - "linear": wait Base times the retry number;
+//   - "exponential": double the wait with every retry, starting at
+//     Base, with jitter taking the actual wait at random between half
+//     the computed delay and all of it, so workers retrying together
+//     spread out.
+//
+// Base defaults to 100ms, and Max, if positive, caps every wait. The
+// zero Backoff waits a fixed 100ms.
+type Backoff struct {
+    Strategy string
+    Base     time.Duration
+    Max      time.Duration
+}
+
+// Delay returns the wait before retry number retry (counting from 1),
+// applying jitter with rng for the exponential strategy; a nil rng
+// gives the delay without jitter.
+func (b Backoff) Delay(retry int, rng *rand.Rand) time.Duration {
+    base := b.Base
+    if base <= 0 {
+        base = defaultBackoffBase
+    }
+    var d time.Duration
+    switch b.Strategy {
+    case "none":
+        return 0
+    case "linear":
+        d = base * time.Duration(retry)
+    case "exponential":
+        d = base
+        for i := 1; i < retry && d < math.MaxInt64/2 && (b.Max <= 0 || d < b.Max); i++ {
+            d *= 2
+        }
+    default:
+        d = base
+    }
+    if b.Max > 0 && d > b.Max {
+        d = b.Max
+    }
+    if b.Strategy == "exponential" && rng != nil && d > 1 {
+        d = d/2 + time.Duration(rng.Int63n(int64(d/2)+1))
+    }
+    return d
+}
+
+// validate rejects an unknown strategy or a negative duration.
+func (b Backoff) validate() error {
+    switch b.Strategy {
+    case "", "none", "fixed", "linear", "exponential":
+    default:
+        return fmt.Errorf("processor: unknown Backoff strategy %q", b.Strategy)
+    }
+    if b.Base < 0 || b.Max < 0 {
+        return fmt.Errorf("processor: Backoff durations must not be negative, got base %v and max %v", b.Base, b.Max)
+    }
+    return nil
+}
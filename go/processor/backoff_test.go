@@ -0,0 +1,80 @@
+package processor
+
+import (
+    "math/rand"
+    "testing"
+    "time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+    ms := time.Millisecond
+    tests := []struct {
+        name    string
+        backoff Backoff
+        want    []time.Duration // for retries 1, 2, ...
+    }{
+        {"zero", Backoff{}, []time.Duration{100 * ms, 100 * ms, 100 * ms}},
+        {"none", Backoff{Strategy: "none", Base: 10 * ms}, []time.Duration{0, 0, 0}},
+        {"fixed", Backoff{Strategy: "fixed", Base: 10 * ms}, []time.Duration{10 * ms, 10 * ms, 10 * ms}},
+        {"linear", Backoff{Strategy: "linear", Base: 10 * ms}, []time.Duration{10 * ms, 20 * ms, 30 * ms, 40 * ms}},
+        {"linear capped", Backoff{Strategy: "linear", Base: 10 * ms, Max: 25 * ms}, []time.Duration{10 * ms, 20 * ms, 25 * ms, 25 * ms}},
+        {"exponential", Backoff{Strategy: "exponential", Base: 10 * ms}, []time.Duration{10 * ms, 20 * ms, 40 * ms, 80 * ms, 160 * ms}},
+        {"exponential capped", Backoff{Strategy: "exponential", Base: 10 * ms, Max: 50 * ms}, []time.Duration{10 * ms, 20 * ms, 40 * ms, 50 * ms, 50 * ms}},
+        {"exponential default base", Backoff{Strategy: "exponential"}, []time.Duration{100 * ms, 200 * ms, 400 * ms}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for i, want := range tt.want {
+                if got := tt.backoff.Delay(i+1, nil); got != want {
+                    t.Errorf("Delay(%d) = %v, want %v", i+1, got, want)
+                }
+            }
+        })
+    }
+}
+
+func TestBackoffDelayExponentialNoOverflow(t *testing.T) {
+    b := Backoff{Strategy: "exponential", Base: 10 * time.Millisecond, Max: time.Second}
+    if got := b.Delay(1000, nil); got != time.Second {
+        t.Errorf("Delay(1000) = %v, want the 1s cap", got)
+    }
+    if got := (Backoff{Strategy: "exponential", Base: time.Second}).Delay(1000, nil); got <= 0 {
+        t.Errorf("uncapped Delay(1000) = %v, want a positive delay", got)
+    }
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+    ms := time.Millisecond
+    b := Backoff{Strategy: "exponential", Base: 10 * ms, Max: 50 * ms}
+    rng := rand.New(rand.NewSource(1))
+    for _, tt := range []struct {
+        retry int
+        full  time.Duration
+    }{{1, 10 * ms}, {2, 20 * ms}, {3, 40 * ms}, {4, 50 * ms}, {10, 50 * ms}} {
+        seen := make(map[time.Duration]bool)
+        for i := 0; i < 200; i++ {
+            got := b.Delay(tt.retry, rng)
+            if got < tt.full/2 || got > tt.full {
+                t.Fatalf("Delay(%d) = %v, want between %v and %v", tt.retry, got, tt.full/2, tt.full)
+            }
+            seen[got] = true
+        }
+        if len(seen) < 2 {
+            t.Errorf("Delay(%d) gave the same wait 200 times; want jitter", tt.retry)
+        }
+    }
+
+    // The jitter only depends on the seed.
+    a, c := rand.New(rand.NewSource(7)), rand.New(rand.NewSource(7))
+    for retry := 1; retry <= 5; retry++ {
+        if x, y := b.Delay(retry, a), b.Delay(retry, c); x != y {
+            t.Errorf("Delay(%d) = %v and %v with the same seed", retry, x, y)
+        }
+    }
+
+    // Only the exponential strategy is jittered.
+    fixed := Backoff{Strategy: "fixed", Base: 10 * ms}
+    if got := fixed.Delay(3, rng); got != 10*ms {
+        t.Errorf("fixed Delay(3) = %v with an rng, want 10ms", got)
+    }
+}
@@ -0,0 +1,96 @@
+package processor
+
+import (
+    "context"
+    "log/slog"
+    "slices"
+    "sync"
+)
+
+// cancelRegistry routes the task IDs received on Config.Cancel to the
+// workers. A task is registered while it is processed, under a context
+// of its own; cancelling its ID cancels that context with
+// ErrTaskCancelled. An ID that is not in flight is remembered, and the
+// next task with that ID is cancelled before it starts. Methods on a
+// nil *cancelRegistry cancel nothing.
+type cancelRegistry struct {
+    mu      sync.Mutex
+    running map[int][]*runningTask
+    pending map[int]bool
+}
+
+// runningTask is one registered task; tasks sharing an ID are all
+// cancelled together.
+type runningTask struct {
+    cancel context.CancelCauseFunc
+}
+
+func newCancelRegistry() *cancelRegistry {
+    return &cancelRegistry{running: make(map[int][]*runningTask), pending: make(map[int]bool)}
+}
+
+// listen applies the requests received on ids until it is closed or
+// ctx is done.
+func (c *cancelRegistry) listen(ctx context.Context, ids <-chan int, log *slog.Logger) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case id, ok := <-ids:
+            if !ok {
+                return
+            }
+            if c.request(id) {
+                log.Info("cancelling task", "task_id", id)
+            } else {
+                log.Info("task will be cancelled when it starts", "task_id", id)
+            }
+        }
+    }
+}
+
+// request cancels the tasks with this ID that are in flight, reporting
+// whether there were any; otherwise it marks the ID to be cancelled
+// when it starts.
+func (c *cancelRegistry) request(id int) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    tasks := c.running[id]
+    if len(tasks) == 0 {
+        c.pending[id] = true
+        return false
+    }
+    for _, t := range tasks {
+        t.cancel(ErrTaskCancelled)
+    }
+    return true
+}
+
+// begin registers the task with this ID and returns the context to
+// process it under and a function to call once it is done. It reports
+// false if the task was cancelled before it started, in which case
+// there is nothing to call.
+func (c *cancelRegistry) begin(ctx context.Context, id int) (context.Context, func(), bool) {
+    if c == nil {
+        return ctx, func() {}, true
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.pending[id] {
+        delete(c.pending, id)
+        return nil, nil, false
+    }
+    taskCtx, cancel := context.WithCancelCause(ctx)
+    t := &runningTask{cancel}
+    c.running[id] = append(c.running[id], t)
+    done := func() {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        c.running[id] = slices.DeleteFunc(c.running[id], func(r *runningTask) bool { return r == t })
+        if len(c.running[id]) == 0 {
+            delete(c.running, id)
+        }
+        cancel(nil)
+    }
+    return taskCtx, done, true
+}
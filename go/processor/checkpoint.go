@@ -0,0 +1,191 @@
+package processor
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// checkpointInterval is how often a run saves its Checkpoint.
+const checkpointInterval = time.Second
+
+// Checkpoint records the IDs of completed tasks in a file, one ID per
+// line, so that a crashed run can be resumed without redoing them. A
+// run marks each task as its result is collected and saves the file
+// every checkpointInterval and once more at the end. Results streamed
+// on Config.Results are not marked by the run, which cannot tell when
+// they are safely stored: the receiver calls Mark for each once it
+// is, for instance from a FileWriter's OnWritten, and Save after the
+// last. Failed tasks are not marked, so a resumed run retries them.
+type Checkpoint struct {
+    path string
+
+    mu    sync.Mutex
+    done  map[int]struct{}
+    marks int // Mark calls so far
+    saved int // marks already in the file
+
+    saving sync.Mutex // held by Save, so that saves do not overlap
+}
+
+// OpenCheckpoint returns a Checkpoint backed by the file at path. With
+// resume, the IDs already recorded there are loaded and count as done;
+// a missing file is an empty checkpoint. Without resume, any existing
+// file is ignored and will be overwritten.
+func OpenCheckpoint(path string, resume bool) (*Checkpoint, error) {
+    c := &Checkpoint{path: path, done: make(map[int]struct{})}
+    if !resume {
+        return c, nil
+    }
+
+    file, err := os.Open(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return c, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("opening checkpoint: %w", err)
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" {
+            continue
+        }
+        id, err := strconv.Atoi(line)
+        if err != nil {
+            return nil, fmt.Errorf("reading checkpoint %s: invalid task ID %q", path, line)
+        }
+        c.done[id] = struct{}{}
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+    }
+
+    return c, nil
+}
+
+// Len returns how many task IDs are marked done.
+func (c *Checkpoint) Len() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return len(c.done)
+}
+
+// Done reports whether the task with the given ID is marked done.
+func (c *Checkpoint) Done(id int) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    _, ok := c.done[id]
+    return ok
+}
+
+// Mark records the task with the given ID as done, to be written out
+// by the next Save.
+func (c *Checkpoint) Mark(id int) {
+    c.mu.Lock()
+    c.done[id] = struct{}{}
+    c.marks++
+    c.mu.Unlock()
+}
+
+// Save writes the done IDs to the checkpoint file, sorted, if any were
+// marked since the last successful save; after a failed one the next
+// Save tries again. The file is written next to its final path, synced
+// and renamed into place, so a crash mid-save leaves the previous
+// checkpoint intact.
+func (c *Checkpoint) Save() error {
+    c.saving.Lock()
+    defer c.saving.Unlock()
+
+    c.mu.Lock()
+    if c.marks == c.saved {
+        c.mu.Unlock()
+        return nil
+    }
+    marks := c.marks
+    ids := make([]int, 0, len(c.done))
+    for id := range c.done {
+        ids = append(ids, id)
+    }
+    c.mu.Unlock()
+    sort.Ints(ids)
+
+    if err := c.write(ids); err != nil {
+        return err
+    }
+    c.mu.Lock()
+    c.saved = marks
+    c.mu.Unlock()
+    return nil
+}
+
+// write replaces the checkpoint file with one listing ids.
+func (c *Checkpoint) write(ids []int) error {
+    tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+    if err != nil {
+        return err
+    }
+    writer := bufio.NewWriter(tmp)
+    for _, id := range ids {
+        fmt.Fprintln(writer, id)
+    }
+    err = writer.Flush()
+    if err == nil {
+        err = tmp.Sync()
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(tmp.Name(), c.path)
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    return syncDir(filepath.Dir(c.path))
+}
+
+// Remove deletes the checkpoint file, e.g. once a run has completed
+// cleanly. A missing file is not an error.
+func (c *Checkpoint) Remove() error {
+    err := os.Remove(c.path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    return err
+}
+
+// autosave saves c every checkpointInterval until stop is closed, then
+// saves one last time and closes done.
+func (c *Checkpoint) autosave(stop <-chan struct{}, done chan<- struct{}, log *slog.Logger) {
+    defer close(done)
+
+    ticker := time.NewTicker(checkpointInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            if err := c.Save(); err != nil {
+                log.Error("saving checkpoint failed", "path", c.path, "error", err)
+            }
+        case <-stop:
+            if err := c.Save(); err != nil {
+                log.Error("saving checkpoint failed", "path", c.path, "error", err)
+            }
+            return
+        }
+    }
+}
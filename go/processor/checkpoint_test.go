@@ -0,0 +1,61 @@
+package processor
+
+import (
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestCheckpointSaveRetriesAfterFailure(t *testing.T) {
+    dir := filepath.Join(t.TempDir(), "missing")
+    path := filepath.Join(dir, "checkpoint")
+    checkpoint, err := OpenCheckpoint(path, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkpoint.Mark(2)
+    checkpoint.Mark(1)
+    if err := checkpoint.Save(); err == nil {
+        t.Fatal("Save into a missing directory succeeded")
+    }
+
+    // With no Mark in between, the next Save must still write the IDs
+    // the failed one did not.
+    if err := os.Mkdir(dir, 0o777); err != nil {
+        t.Fatal(err)
+    }
+    if err := checkpoint.Save(); err != nil {
+        t.Fatal(err)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) != "1\n2\n" {
+        t.Errorf("checkpoint file = %q, want %q", data, "1\n2\n")
+    }
+
+    // Once saved, nothing is written again until the next Mark.
+    if err := os.Remove(path); err != nil {
+        t.Fatal(err)
+    }
+    if err := checkpoint.Save(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+        t.Errorf("Save without new marks wrote the file again (stat error %v)", err)
+    }
+    checkpoint.Mark(3)
+    if err := checkpoint.Save(); err != nil {
+        t.Fatal(err)
+    }
+    resumed, err := OpenCheckpoint(path, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if resumed.Len() != 3 || !resumed.Done(1) || !resumed.Done(3) {
+        t.Errorf("resumed checkpoint has %d IDs, want 1, 2 and 3", resumed.Len())
+    }
+}
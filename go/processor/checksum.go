@@ -0,0 +1,46 @@
+package processor
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// ChecksumSuffix is appended to an output file's name to name its
+// checksum file.
+const ChecksumSuffix = ".sha256"
+
+// OutputChecksum returns the hex SHA-256 of a result's output, as set
+// in Result.SHA256 by a Worker with Checksum.
+func OutputChecksum(output string) string {
+    sum := sha256.Sum256([]byte(output))
+    return hex.EncodeToString(sum[:])
+}
+
+// WriteChecksumFile hashes the file at path, as written (compressed,
+// for a ".gz" file), and records the SHA-256 next to it in
+// path+ChecksumSuffix, in the "<hex>  <name>" format of sha256sum, so
+// that `sha256sum -c` in the same directory verifies it later. It
+// returns the checksum.
+func WriteChecksumFile(path string) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    sum := hex.EncodeToString(hash.Sum(nil))
+
+    line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+    if err := os.WriteFile(path+ChecksumSuffix, []byte(line), 0o666); err != nil {
+        return "", err
+    }
+    return sum, nil
+}
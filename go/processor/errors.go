@@ -0,0 +1,69 @@
+package processor
+
+import (
+    "errors"
+    "sort"
+)
+
+// ErrInvalidInput is the kind of a Failure whose task data cannot be
+// processed at all, such as data that is not valid UTF-8. Retrying it
+// gives the same result, so such tasks are not retried.
+var ErrInvalidInput = errors.New("invalid input")
+
+// ProcessError is the error a worker records when a task fails. Kind
+// is one of the sentinels ErrInvalidInput, ErrTaskTimeout,
+// ErrTaskCancelled or ErrTransformPanic and Err gives the details;
+// errors.Is matches both, so callers can branch on the category with
+// errors.Is(failure, ErrTaskTimeout) or read it with errors.As.
+type ProcessError struct {
+    Kind error
+    Err  error
+}
+
+// Error reports the kind followed by the details.
+func (e *ProcessError) Error() string {
+    return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the kind and the underlying error.
+func (e *ProcessError) Unwrap() []error {
+    return []error{e.Kind, e.Err}
+}
+
+// Kind returns the sentinel that classifies the failure, or nil if its
+// error is not a *ProcessError.
+func (f *Failure) Kind() error {
+    var pe *ProcessError
+    if errors.As(f.Err, &pe) {
+        return pe.Kind
+    }
+    return nil
+}
+
+// KindName names a failure kind for summaries and logs: the sentinel's
+// message, or "other" for nil.
+func KindName(kind error) string {
+    if kind == nil {
+        return "other"
+    }
+    return kind.Error()
+}
+
+// CountFailureKinds counts failures by KindName.
+func CountFailureKinds(failures []Failure) map[string]int {
+    counts := make(map[string]int)
+    for i := range failures {
+        counts[KindName(failures[i].Kind())]++
+    }
+    return counts
+}
+
+// sortedKinds returns the keys of counts in alphabetical order.
+func sortedKinds(counts map[string]int) []string {
+    kinds := make([]string, 0, len(counts))
+    for kind := range counts {
+        kinds = append(kinds, kind)
+    }
+    sort.Strings(kinds)
+    return kinds
+}
@@ -0,0 +1,160 @@
+package processor
+
+import (
+    "bufio"
+    "fmt"
+    "math/rand"
+    "strings"
+    "text/template"
+)
+
+// TaskSource produces the data of synthetic tasks, for workloads that
+// need no input file.
+type TaskSource interface {
+    // Data returns the data for the task with the given ID; IDs start
+    // at 1.
+    Data(id int) string
+}
+
+// GenerateTasks builds n synthetic tasks with IDs 1..n and data
+// of the form "task_data_<id>".
+func GenerateTasks(n int) []Task {
+    return GenerateFrom(NumberedSource{}, n)
+}
+
+// GenerateFrom builds n tasks with IDs 1..n and data from source.
+func GenerateFrom(source TaskSource, n int) []Task {
+    taskList := make([]Task, 0, n)
+    for i := 1; i <= n; i++ {
+        taskList = append(taskList, Task{ID: i, Data: source.Data(i)})
+    }
+    return taskList
+}
+
+// NumberedSource is the default TaskSource: "task_data_<id>".
+type NumberedSource struct{}
+
+// Data implements TaskSource.
+func (NumberedSource) Data(id int) string {
+    return fmt.Sprintf("task_data_%d", id)
+}
+
+// RandomSource generates strings of Length random lowercase letters.
+// Rand is not safe for concurrent use, so neither is the source.
+type RandomSource struct {
+    Length int
+    Rand   *rand.Rand
+}
+
+// Data implements TaskSource.
+func (s RandomSource) Data(int) string {
+    return randomLetters(s.Rand, s.Length)
+}
+
+// SkewedSource generates random lowercase strings of two sizes for a
+// skewed workload: a LongShare fraction (0 to 1) of the tasks, picked
+// at random, get Long letters and the rest Short, e.g. 80% of 16 and
+// 20% of 4096. Rand is not safe for concurrent use, so neither is the
+// source.
+type SkewedSource struct {
+    Short     int
+    Long      int
+    LongShare float64
+    Rand      *rand.Rand
+}
+
+// Data implements TaskSource.
+func (s SkewedSource) Data(int) string {
+    n := s.Short
+    if s.Rand.Float64() < s.LongShare {
+        n = s.Long
+    }
+    return randomLetters(s.Rand, n)
+}
+
+// randomLetters returns n random lowercase letters drawn from rng.
+func randomLetters(rng *rand.Rand, n int) string {
+    const letters = "abcdefghijklmnopqrstuvwxyz"
+    b := make([]byte, n)
+    for i := range b {
+        b[i] = letters[rng.Intn(len(letters))]
+    }
+    return string(b)
+}
+
+// WordSource generates Count words picked at random from Words,
+// separated by spaces. Rand is not safe for concurrent use, so neither
+// is the source.
+type WordSource struct {
+    Words []string
+    Count int
+    Rand  *rand.Rand
+}
+
+// Data implements TaskSource.
+func (s WordSource) Data(int) string {
+    words := make([]string, s.Count)
+    for i := range words {
+        words[i] = s.Words[s.Rand.Intn(len(s.Words))]
+    }
+    return strings.Join(words, " ")
+}
+
+// ReadWords reads a dictionary file with one word per line, such as
+// /usr/share/dict/words, skipping blank lines.
+func ReadWords(path string) ([]string, error) {
+    file, err := openInput(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var words []string
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        if word := strings.TrimSpace(scanner.Text()); word != "" {
+            words = append(words, word)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(words) == 0 {
+        return nil, fmt.Errorf("%s: no words found", path)
+    }
+    return words, nil
+}
+
+// TemplateSource renders the same text/template for every task, with
+// the task's ID available as {{.ID}}, e.g. "order {{.ID}} for user
+// {{printf \"%03d\" .ID}}".
+type TemplateSource struct {
+    tmpl *template.Template
+}
+
+// NewTemplateSource parses text as a TemplateSource, failing if it does
+// not parse or does not render.
+func NewTemplateSource(text string) (TemplateSource, error) {
+    tmpl, err := template.New("task").Option("missingkey=error").Parse(text)
+    if err != nil {
+        return TemplateSource{}, fmt.Errorf("processor: invalid task template: %w", err)
+    }
+    s := TemplateSource{tmpl}
+    if err := tmpl.Execute(new(strings.Builder), s.vars(1)); err != nil {
+        return TemplateSource{}, fmt.Errorf("processor: invalid task template: %w", err)
+    }
+    return s, nil
+}
+
+// Data implements TaskSource.
+func (s TemplateSource) Data(id int) string {
+    var b strings.Builder
+    // The template executed cleanly when it was parsed, and only the
+    // ID changes from task to task.
+    s.tmpl.Execute(&b, s.vars(id))
+    return b.String()
+}
+
+func (s TemplateSource) vars(id int) struct{ ID int } {
+    return struct{ ID int }{id}
+}
@@ -0,0 +1,91 @@
+package processor
+
+import (
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// isGzip reports whether path names a gzip-compressed file, judged by
+// its ".gz" suffix.
+func isGzip(path string) bool {
+    return strings.HasSuffix(path, ".gz")
+}
+
+// gzipFile is a gzip stream written to a file. Closing it finishes the
+// stream and then closes the file.
+type gzipFile struct {
+    *gzip.Writer
+    file *os.File
+}
+
+func (g gzipFile) Close() error {
+    err := g.Writer.Close()
+    if closeErr := g.file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// openOutput opens path for writing with the given os.OpenFile flags.
+// If path ends in ".gz", what is written is gzip-compressed; appending
+// to such a file adds a new gzip member, which readers treat as a
+// continuation of the same stream. With os.O_CREATE, missing parent
+// directories are created first, so a path like results/2024/out.txt
+// works in a fresh tree.
+func openOutput(path string, flag int) (io.WriteCloser, error) {
+    if flag&os.O_CREATE != 0 {
+        if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+            return nil, fmt.Errorf("cannot create the directory for %s: %w", path, err)
+        }
+    }
+    file, err := os.OpenFile(path, flag, 0o666)
+    if err != nil {
+        return nil, err
+    }
+    if !isGzip(path) {
+        return file, nil
+    }
+    return gzipFile{gzip.NewWriter(file), file}, nil
+}
+
+// createOutput is openOutput for a new or truncated file, like
+// os.Create.
+func createOutput(path string) (io.WriteCloser, error) {
+    return openOutput(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+}
+
+// gzipReader is a gzip stream read from a file.
+type gzipReader struct {
+    *gzip.Reader
+    file *os.File
+}
+
+func (g gzipReader) Close() error {
+    err := g.Reader.Close()
+    if closeErr := g.file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// openInput opens path for reading, decompressing it if it ends in
+// ".gz".
+func openInput(path string) (io.ReadCloser, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    if !isGzip(path) {
+        return file, nil
+    }
+    gz, err := gzip.NewReader(file)
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    return gzipReader{gz, file}, nil
+}
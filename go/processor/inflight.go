@@ -0,0 +1,41 @@
+package processor
+
+import "context"
+
+// inflightLimit caps how many tasks are processed at the same time,
+// across all workers, at Config.MaxInFlight: a worker takes a slot
+// before processing a task and gives it back afterwards. Methods on a
+// nil *inflightLimit never wait.
+type inflightLimit struct {
+    slots chan struct{}
+}
+
+// newInflightLimit returns a limit of n slots, or nil (no limit) for an
+// n of 0 or one that the pool cannot exceed anyway with its workers.
+func newInflightLimit(n, workers int) *inflightLimit {
+    if n <= 0 || n >= workers {
+        return nil
+    }
+    return &inflightLimit{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot and reports true once it holds one, or
+// false if ctx is done first.
+func (l *inflightLimit) acquire(ctx context.Context) bool {
+    if l == nil {
+        return true
+    }
+    select {
+    case l.slots <- struct{}{}:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
+
+// release gives back a slot taken by acquire.
+func (l *inflightLimit) release() {
+    if l != nil {
+        <-l.slots
+    }
+}
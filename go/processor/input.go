@@ -0,0 +1,610 @@
+package processor
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "log/slog"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// RepeatTasks returns n copies of taskList back to back, for building a
+// larger workload from a small input. The first copy keeps the original
+// IDs; copy r (counting from 0) adds r times the highest ID to each, so
+// every ID stays unique and task k of any copy is ID k modulo that
+// highest ID. n below 1 is treated as 1.
+func RepeatTasks(taskList []Task, n int) []Task {
+    if n <= 1 {
+        return taskList
+    }
+    maxID := 0
+    for _, task := range taskList {
+        maxID = max(maxID, task.ID)
+    }
+    repeated := make([]Task, 0, len(taskList)*n)
+    for r := 0; r < n; r++ {
+        for _, task := range taskList {
+            task.ID += r * maxID
+            repeated = append(repeated, task)
+        }
+    }
+    return repeated
+}
+
+// ShuffleTasks puts taskList into a random order, in place, for
+// checking that nothing depends on the dispatch order. The same seed
+// always gives the same order.
+func ShuffleTasks(taskList []Task, seed int64) {
+    rng := rand.New(rand.NewSource(seed))
+    rng.Shuffle(len(taskList), func(i, j int) {
+        taskList[i], taskList[j] = taskList[j], taskList[i]
+    })
+}
+
+// ContentID returns the task ID derived from data under the "content"
+// IDMode: the first 48 bits of its SHA-256, so the same data always
+// gets the same ID, in any run. A collision between different data is
+// unlikely below some millions of tasks but not impossible. The result
+// is never 0, which marks a task without an ID.
+func ContentID(data string) int {
+    sum := sha256.Sum256([]byte(data))
+    id := int(binary.BigEndian.Uint64(sum[:8]) >> 16)
+    return max(id, 1)
+}
+
+// AssignContentIDs replaces the ID of every task in taskList, in
+// place, with the ContentID of its data, for tasks built without a
+// LoadOptions, such as generated ones.
+func AssignContentIDs(taskList []Task) {
+    for i := range taskList {
+        taskList[i].ID = ContentID(taskList[i].Data)
+    }
+}
+
+// LoadOptions controls how input lines are turned into tasks.
+type LoadOptions struct {
+    // Priorities parses an optional "<priority>:" prefix on each line
+    // into Task.Priority, e.g. "5:urgent work". Lines without a valid
+    // integer prefix keep priority 0 and their full text as data.
+    Priorities bool
+
+    // Dedupe skips any task whose Data was already seen on an earlier
+    // line, keeping only the first occurrence. Duplicates are dropped
+    // before IDs are assigned, so the remaining IDs stay contiguous.
+    Dedupe bool
+
+    // Format selects how lines are parsed: "text" (or empty), where
+    // each line is the task data and IDs follow line order; "jsonl",
+    // where each line is a JSON object such as
+    // {"id": 7, "data": "text", "priority": 1} carrying its own ID; or
+    // "csv", see Column. Priorities applies to text input only.
+    Format string
+
+    // For "csv" input, Column selects the field holding the task data
+    // and IDColumn the field holding its ID, each as a 1-based index
+    // or a header name. Column defaults to the first field; without
+    // IDColumn, IDs follow row order. The first row is a header unless
+    // NoHeader is set.
+    Column   string
+    IDColumn string
+    NoHeader bool
+
+    // For "csv" input, TagColumns selects fields, each as a 1-based
+    // index or a header name, to copy into every task's Tags, keyed by
+    // the column's header name, or by the spec as given without a
+    // header. JSON Lines input carries its tags as a "tags" object of
+    // strings instead.
+    TagColumns []string
+
+    // Strict makes an invalid JSON Lines line or CSV row an error that
+    // stops loading; otherwise it is logged with its line number,
+    // counted in LoadStats.Invalid and skipped.
+    Strict bool
+
+    // IDMode selects how tasks without an ID of their own are numbered:
+    // "sequential" (or empty) counts them in input order from 1;
+    // "content" uses the ContentID of their data, so results can be
+    // joined across runs. Identical data then shares an ID unless
+    // Dedupe drops the repeats.
+    IDMode string
+
+    // Limit, if positive, stops loading once that many tasks have been
+    // accepted, without reading the rest of the input, which may or
+    // may not hold more; see LoadStats.LimitReached.
+    Limit int
+
+    // Logger receives warnings about skipped lines; nil discards them.
+    Logger *slog.Logger
+
+    // Stats, if set, receives counts of the lines that were read but
+    // not turned into tasks. With StreamTasks it is complete once the
+    // task channel has been closed.
+    Stats *LoadStats
+}
+
+// LoadStats counts the input lines skipped while loading tasks, and
+// for LoadTaskDir the files that could not be read.
+type LoadStats struct {
+    Duplicates int
+    Invalid    int
+    Unreadable int
+
+    // LimitReached is set when the load stopped at LoadOptions.Limit.
+    LimitReached bool
+}
+
+// LoadTasksFromFile reads the file at path line by line and turns
+// every non-empty line into a Task. IDs are assigned in order,
+// starting at 1; empty lines are skipped and do not consume an ID.
+func LoadTasksFromFile(path string) ([]Task, error) {
+    return LoadTasks(path, LoadOptions{})
+}
+
+// LoadTasks is LoadTasksFromFile with options. Every task records path
+// as its Source.
+func LoadTasks(path string, opts LoadOptions) ([]Task, error) {
+    return LoadTaskFiles([]string{path}, opts)
+}
+
+// LoadTaskFiles reads several input files in order into one task list,
+// as LoadTasks does for one. IDs run on continuously from one file to
+// the next (and Dedupe applies across files); each task's Source names
+// the file it came from.
+func LoadTaskFiles(paths []string, opts LoadOptions) ([]Task, error) {
+    var taskList []Task
+    parser := newLineParser(opts)
+    for _, path := range paths {
+        if parser.full {
+            break
+        }
+        var err error
+        parser.source = path
+        taskList, err = loadFile(path, parser, taskList)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return taskList, nil
+}
+
+// LoadTaskDir makes one task per regular file in dir, in lexical order,
+// with the file's entire contents as Data and its path relative to dir
+// as Source; subdirectories are only walked if recursive is set.
+// Symbolic links are followed to files but never to directories, so a
+// link cycle cannot trap the walk. A file or subdirectory that cannot
+// be read is logged, counted in LoadStats.Unreadable and skipped, or
+// stops the load under Strict. Files ending in ".gz" are decompressed.
+// Dedupe compares whole contents; Format and Priorities do not apply.
+func LoadTaskDir(dir string, recursive bool, opts LoadOptions) ([]Task, error) {
+    parser := newLineParser(opts)
+    var taskList []Task
+
+    unreadable := func(path string, err error) error {
+        if opts.Strict {
+            return err
+        }
+        parser.stats.Unreadable++
+        parser.opts.Logger.Warn("skipping unreadable input file", "path", path, "error", err)
+        return nil
+    }
+    err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+        if err != nil {
+            if path == dir {
+                return err
+            }
+            return unreadable(path, err)
+        }
+        if parser.full {
+            return fs.SkipAll
+        }
+        if entry.IsDir() {
+            if path != dir && !recursive {
+                return fs.SkipDir
+            }
+            return nil
+        }
+
+        info, err := os.Stat(path)
+        if err != nil {
+            return unreadable(path, err)
+        }
+        if info.IsDir() {
+            parser.opts.Logger.Debug("not following symlink to directory", "path", path)
+            return nil
+        }
+        if !info.Mode().IsRegular() {
+            return nil
+        }
+        data, err := readInputFile(path)
+        if err != nil {
+            return unreadable(path, err)
+        }
+
+        parser.source, _ = filepath.Rel(dir, path)
+        if task, ok, _ := parser.accept(Task{Data: data}); ok {
+            taskList = append(taskList, task)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, fmt.Errorf("reading input directory %s: %w", dir, err)
+    }
+    return taskList, nil
+}
+
+// readInputFile returns the whole contents of the file at path,
+// decompressed if the name ends in ".gz".
+func readInputFile(path string) (string, error) {
+    file, err := openInput(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    data, err := io.ReadAll(file)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
+
+// loadFile appends the tasks parsed from the file at path to taskList,
+// decompressing it first if the name ends in ".gz".
+func loadFile(path string, parser *lineParser, taskList []Task) ([]Task, error) {
+    file, err := openInput(path)
+    if err != nil {
+        return nil, fmt.Errorf("opening input file: %w", err)
+    }
+    defer file.Close()
+
+    taskList, err = parser.read(file, taskList)
+    if err != nil {
+        return nil, fmt.Errorf("reading input file %s: %w", path, err)
+    }
+    return taskList, nil
+}
+
+// ReadTasks reads tasks from r, one per non-empty line, as described
+// for LoadTasksFromFile.
+func ReadTasks(r io.Reader, opts LoadOptions) ([]Task, error) {
+    return newLineParser(opts).read(r, nil)
+}
+
+// StreamTasks reads tasks from r like ReadTasks, but hands them out one
+// at a time over the returned channel as lines arrive, so arbitrarily
+// large inputs (e.g. a shell pipeline on stdin) are never held in
+// memory at once. The channel is unbuffered, so reading only runs as
+// far ahead as the consumer. It is closed at end of input or when ctx
+// is cancelled; after that, the returned function reports any read
+// error.
+func StreamTasks(ctx context.Context, r io.Reader, opts LoadOptions) (<-chan Task, func() error) {
+    stream := make(chan Task)
+    var readErr error
+    go func() {
+        defer close(stream)
+        readErr = newLineParser(opts).scan(r, func(task Task) bool {
+            select {
+            case stream <- task:
+                return true
+            case <-ctx.Done():
+                return false
+            }
+        })
+    }()
+    return stream, func() error { return readErr }
+}
+
+// lineParser turns input lines into tasks for ReadTasks, StreamTasks
+// and LoadTaskFiles, assigning IDs and applying the LoadOptions.
+type lineParser struct {
+    opts   LoadOptions
+    stats  *LoadStats
+    seen   map[string]struct{}
+    nextID int
+    source string
+    lineNo int
+
+    // accepted counts the tasks accepted so far, and full is set once
+    // it reaches LoadOptions.Limit.
+    accepted int
+    full     bool
+}
+
+func newLineParser(opts LoadOptions) *lineParser {
+    p := &lineParser{opts: opts, stats: opts.Stats, nextID: 1}
+    if p.stats == nil {
+        p.stats = new(LoadStats)
+    }
+    if p.opts.Logger == nil {
+        p.opts.Logger = discardLogger
+    }
+    if opts.Dedupe {
+        p.seen = make(map[string]struct{})
+    }
+    return p
+}
+
+// read appends a task to taskList for every record of r that the
+// parser accepts.
+func (p *lineParser) read(r io.Reader, taskList []Task) ([]Task, error) {
+    err := p.scan(r, func(task Task) bool {
+        taskList = append(taskList, task)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    return taskList, nil
+}
+
+// scan parses r in the configured Format and passes each accepted task
+// to emit, stopping early (without error) if emit reports false.
+func (p *lineParser) scan(r io.Reader, emit func(Task) bool) error {
+    p.lineNo = 0
+    if p.opts.Format == "csv" {
+        return p.scanCSV(r, emit)
+    }
+
+    // A bufio.Reader rather than a Scanner, whose 64KB token limit
+    // would abort the load on a long line: lines of any length are
+    // read whole, and Config.MaxDataLen decides what happens to them.
+    reader := bufio.NewReader(r)
+    for {
+        line, readErr := reader.ReadString('\n')
+        if readErr != nil && readErr != io.EOF {
+            return readErr
+        }
+        if line == "" && readErr == io.EOF {
+            return nil
+        }
+        task, ok, err := p.parse(trimEOL(line))
+        if err != nil {
+            return err
+        }
+        if ok && !emit(task) {
+            return nil
+        }
+        if p.full || readErr == io.EOF {
+            return nil
+        }
+    }
+}
+
+// trimEOL drops the "\n" or "\r\n" ending line, as bufio.ScanLines does.
+func trimEOL(line string) string {
+    return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
+
+// parse builds the Task for one input line, reporting false if the
+// line is skipped: empty, a duplicate under Dedupe, or invalid JSON
+// Lines input without Strict. Under Strict an invalid line is an error.
+func (p *lineParser) parse(line string) (Task, bool, error) {
+    p.lineNo++
+    if line == "" || (p.opts.Format == "jsonl" && strings.TrimSpace(line) == "") {
+        return Task{}, false, nil
+    }
+
+    var task Task
+    if p.opts.Format == "jsonl" {
+        var err error
+        if task, err = parseJSONLine(line); err != nil {
+            return p.invalid(err)
+        }
+    } else {
+        task = Task{Data: line}
+        if p.opts.Priorities {
+            task.Priority, task.Data = parsePriority(line)
+        }
+    }
+    return p.accept(task)
+}
+
+// invalid handles a malformed record on the current line: an error
+// under Strict, otherwise a logged and counted skip.
+func (p *lineParser) invalid(err error) (Task, bool, error) {
+    if p.opts.Strict {
+        return Task{}, false, fmt.Errorf("line %d: %w", p.lineNo, err)
+    }
+    p.stats.Invalid++
+    p.opts.Logger.Warn("skipping invalid input line", "source", p.source, "line", p.lineNo, "error", err)
+    return Task{}, false, nil
+}
+
+// accept finishes a parsed task: it records the source, drops it if it
+// is a duplicate under Dedupe, assigns the next ID unless the input
+// supplied one, and notes when the Limit is reached.
+func (p *lineParser) accept(task Task) (Task, bool, error) {
+    task.Source = p.source
+
+    if p.seen != nil {
+        if _, dup := p.seen[task.Data]; dup {
+            p.stats.Duplicates++
+            return Task{}, false, nil
+        }
+        p.seen[task.Data] = struct{}{}
+    }
+    if task.ID == 0 && p.opts.IDMode == "content" {
+        task.ID = ContentID(task.Data)
+    } else if task.ID == 0 {
+        task.ID = p.nextID
+        p.nextID++
+    }
+    p.accepted++
+    if p.opts.Limit > 0 && p.accepted == p.opts.Limit {
+        p.full, p.stats.LimitReached = true, true
+    }
+    return task, true, nil
+}
+
+// scanCSV is scan for CSV input: the data comes from the Column field
+// of each row and the ID from the IDColumn field, if set, or else from
+// the row's position. Rows may be ragged; one that lacks a selected
+// column, or has an invalid ID, is handled like an invalid JSON Lines
+// line. Malformed CSV (such as a stray quote) always stops the load.
+func (p *lineParser) scanCSV(r io.Reader, emit func(Task) bool) error {
+    reader := csv.NewReader(r)
+    reader.FieldsPerRecord = -1
+
+    var header []string
+    if !p.opts.NoHeader {
+        var err error
+        if header, err = reader.Read(); err != nil {
+            if errors.Is(err, io.EOF) {
+                return nil
+            }
+            return err
+        }
+    }
+    dataCol, err := csvColumn(p.opts.Column, header, 0)
+    if err != nil {
+        return fmt.Errorf("data column: %w", err)
+    }
+    idCol, err := csvColumn(p.opts.IDColumn, header, -1)
+    if err != nil {
+        return fmt.Errorf("ID column: %w", err)
+    }
+    var tagCols []csvTag
+    for _, spec := range p.opts.TagColumns {
+        col, err := csvColumn(spec, header, -1)
+        if err != nil {
+            return fmt.Errorf("tag column: %w", err)
+        }
+        key := spec
+        if col < len(header) {
+            key = header[col]
+        }
+        tagCols = append(tagCols, csvTag{key, col})
+    }
+
+    for {
+        record, err := reader.Read()
+        if errors.Is(err, io.EOF) {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        p.lineNo, _ = reader.FieldPos(0)
+
+        task, ok, err := p.parseCSVRecord(record, dataCol, idCol, tagCols)
+        if err != nil {
+            return err
+        }
+        if ok && !emit(task) {
+            return nil
+        }
+        if p.full {
+            return nil
+        }
+    }
+}
+
+// csvTag is a CSV column copied into the tag key.
+type csvTag struct {
+    key string
+    col int
+}
+
+// parseCSVRecord builds the Task for one CSV row.
+func (p *lineParser) parseCSVRecord(record []string, dataCol, idCol int, tagCols []csvTag) (Task, bool, error) {
+    if len(record) == 1 && record[0] == "" {
+        return Task{}, false, nil
+    }
+    need := max(dataCol, idCol)
+    for _, tag := range tagCols {
+        need = max(need, tag.col)
+    }
+    if need >= len(record) {
+        return p.invalid(fmt.Errorf("row has %d fields, need column %d", len(record), need+1))
+    }
+
+    task := Task{Data: record[dataCol]}
+    if len(tagCols) > 0 {
+        task.Tags = make(map[string]string, len(tagCols))
+        for _, tag := range tagCols {
+            task.Tags[tag.key] = record[tag.col]
+        }
+    }
+    if idCol >= 0 {
+        id, err := strconv.Atoi(strings.TrimSpace(record[idCol]))
+        if err != nil || id <= 0 {
+            return p.invalid(fmt.Errorf("invalid task ID %q", record[idCol]))
+        }
+        task.ID = id
+    }
+    return p.accept(task)
+}
+
+// csvColumn resolves a column given as a 1-based index or a header
+// name to a 0-based field index; an empty spec gives def.
+func csvColumn(spec string, header []string, def int) (int, error) {
+    if spec == "" {
+        return def, nil
+    }
+    if n, err := strconv.Atoi(spec); err == nil {
+        if n < 1 {
+            return 0, fmt.Errorf("column index must be 1 or more, got %d", n)
+        }
+        return n - 1, nil
+    }
+    if header == nil {
+        return 0, fmt.Errorf("column %q is a name, but the input has no header row", spec)
+    }
+    for i, name := range header {
+        if strings.TrimSpace(name) == spec {
+            return i, nil
+        }
+    }
+    return 0, fmt.Errorf("no column named %q in header %q", spec, header)
+}
+
+// jsonTask is the shape of one line of JSON Lines input.
+type jsonTask struct {
+    ID       *int              `json:"id"`
+    Data     *string           `json:"data"`
+    Priority int               `json:"priority"`
+    Tags     map[string]string `json:"tags"`
+}
+
+// parseJSONLine decodes a {"id":..,"data":".."} line into a Task; both
+// fields are required and the ID must be positive. An optional "tags"
+// object of strings becomes the Task's Tags.
+func parseJSONLine(line string) (Task, error) {
+    var jt jsonTask
+    if err := json.Unmarshal([]byte(line), &jt); err != nil {
+        return Task{}, fmt.Errorf("invalid JSON: %w", err)
+    }
+    if jt.ID == nil || *jt.ID <= 0 {
+        return Task{}, errors.New(`missing or non-positive "id"`)
+    }
+    if jt.Data == nil {
+        return Task{}, errors.New(`missing "data"`)
+    }
+    return Task{ID: *jt.ID, Data: *jt.Data, Priority: jt.Priority, Tags: jt.Tags}, nil
+}
+
+// parsePriority splits a "<priority>:<data>" line. If the text before
+// the first colon is not an integer, the whole line is data with
+// priority 0.
+func parsePriority(line string) (int, string) {
+    prefix, data, ok := strings.Cut(line, ":")
+    if !ok {
+        return 0, line
+    }
+    priority, err := strconv.Atoi(strings.TrimSpace(prefix))
+    if err != nil {
+        return 0, line
+    }
+    return priority, data
+}
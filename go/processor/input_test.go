@@ -0,0 +1,73 @@
+package processor
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestReadTasksLongLine(t *testing.T) {
+    long := strings.Repeat("x", 70000)
+    input := "short\r\n" + long + "\nlast"
+    tasks, err := ReadTasks(strings.NewReader(input), LoadOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"short", long, "last"}
+    if len(tasks) != len(want) {
+        t.Fatalf("got %d tasks, want %d", len(tasks), len(want))
+    }
+    for i, task := range tasks {
+        if task.Data != want[i] {
+            t.Errorf("Task-%d has %d characters of data, want %d", task.ID, len(task.Data), len(want[i]))
+        }
+    }
+
+    results, err := Run(Config{Tasks: tasks, Workers: 1, MaxDataLen: 100, NoDelay: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, r := range results {
+        if r.TaskID == 2 && r.Length != 100 {
+            t.Errorf("long line processed to %d characters, want 100 under MaxDataLen", r.Length)
+        }
+    }
+}
+
+func TestLoadTasksGzipRoundTrip(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "tasks.txt.gz")
+    tasks := GenerateTasks(50)
+    file, err := createOutput(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, task := range tasks {
+        fmt.Fprintln(file, task.Data)
+    }
+    if err := file.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+        t.Fatalf("%s is not gzip-compressed", path)
+    }
+
+    loaded, err := LoadTasks(path, LoadOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(loaded) != len(tasks) {
+        t.Fatalf("loaded %d tasks, want %d", len(loaded), len(tasks))
+    }
+    for i, task := range loaded {
+        if task.ID != tasks[i].ID || task.Data != tasks[i].Data {
+            t.Errorf("loaded Task-%d %q, want Task-%d %q", task.ID, task.Data, tasks[i].ID, tasks[i].Data)
+        }
+    }
+}
@@ -0,0 +1,150 @@
+package processor
+
+import (
+    "math"
+    "sort"
+)
+
+// LatencyBuckets are the upper bounds, in milliseconds, of the
+// histogram buckets used by Summary.Latency. They are spread over the
+// 200–500ms simulated delay; a final bucket catches anything slower.
+var LatencyBuckets = []float64{250, 300, 350, 400, 450, 500}
+
+// LatencyBucket counts the tasks whose latency was at most UpperMS and
+// above the previous bucket's bound. The last bucket of a
+// LatencyStats has UpperMS +Inf.
+type LatencyBucket struct {
+    UpperMS float64
+    Count   int
+}
+
+// LatencyStats describes the distribution of per-task latencies, each
+// the simulated delay plus the processing time (Result.DelayMS +
+// Result.ProcessMS), in milliseconds. The bucket counts are exact; the
+// percentiles are nearest-rank estimates within latencyPrecision (1%)
+// of the exact value, and are 0 when there are no tasks.
+type LatencyStats struct {
+    Count         int
+    Buckets       []LatencyBucket
+    P50, P90, P99 float64
+}
+
+// latencyPrecision is the relative width of the fine buckets that the
+// percentiles are estimated from.
+const latencyPrecision = 0.01
+
+// latencyHistogram counts latencies as results arrive, in the
+// LatencyBuckets and in fine logarithmic buckets for the percentiles,
+// so that its size depends on the spread of the latencies rather than
+// the number of results: a few thousand fine buckets span a
+// microsecond to hours.
+type latencyHistogram struct {
+    count    int
+    buckets  []int
+    fine     map[int]int
+    min, max float64
+}
+
+// resultLatency is the latency of one result, in milliseconds.
+func resultLatency(r Result) float64 {
+    return float64(r.DelayMS) + r.ProcessMS
+}
+
+// fineBucket returns the index of the fine bucket holding ms: the
+// smallest i with ms <= (1+latencyPrecision)^i, or math.MinInt for a
+// latency of 0.
+func fineBucket(ms float64) int {
+    if ms <= 0 {
+        return math.MinInt
+    }
+    return int(math.Ceil(math.Log(ms) / math.Log1p(latencyPrecision)))
+}
+
+// add counts one latency.
+func (h *latencyHistogram) add(ms float64) {
+    if h.count == 0 || ms < h.min {
+        h.min = ms
+    }
+    if h.count == 0 || ms > h.max {
+        h.max = ms
+    }
+    h.count++
+    if h.buckets == nil {
+        h.buckets = make([]int, len(LatencyBuckets)+1)
+    }
+    h.buckets[sort.Search(len(LatencyBuckets), func(i int) bool { return ms <= LatencyBuckets[i] })]++
+    if h.fine == nil {
+        h.fine = make(map[int]int)
+    }
+    h.fine[fineBucket(ms)]++
+}
+
+// merge folds o into h.
+func (h *latencyHistogram) merge(o latencyHistogram) {
+    if o.count == 0 {
+        return
+    }
+    if h.count == 0 || o.min < h.min {
+        h.min = o.min
+    }
+    if h.count == 0 || o.max > h.max {
+        h.max = o.max
+    }
+    h.count += o.count
+    if h.buckets == nil {
+        h.buckets = make([]int, len(o.buckets))
+    }
+    for i, n := range o.buckets {
+        h.buckets[i] += n
+    }
+    if h.fine == nil {
+        h.fine = make(map[int]int, len(o.fine))
+    }
+    for i, n := range o.fine {
+        h.fine[i] += n
+    }
+}
+
+// percentile estimates the nearest-rank p-th percentile of the
+// latencies counted in h, which must not be empty: the upper bound of
+// the fine bucket holding that rank, clamped to the observed range.
+func (h latencyHistogram) percentile(p float64) float64 {
+    rank := max(int(math.Ceil(p/100*float64(h.count))), 1)
+    indexes := make([]int, 0, len(h.fine))
+    for i := range h.fine {
+        indexes = append(indexes, i)
+    }
+    sort.Ints(indexes)
+    seen := 0
+    for _, i := range indexes {
+        if seen += h.fine[i]; seen >= rank {
+            if i == math.MinInt {
+                return 0
+            }
+            return math.Min(math.Max(math.Pow(1+latencyPrecision, float64(i)), h.min), h.max)
+        }
+    }
+    return h.max
+}
+
+// Latency returns the latency histogram over LatencyBuckets and the
+// percentiles of the results folded into s.
+func (s Summary) Latency() LatencyStats {
+    h := s.latency
+    stats := LatencyStats{Count: h.count}
+    for i, upper := range LatencyBuckets {
+        stats.Buckets = append(stats.Buckets, LatencyBucket{UpperMS: upper})
+        if i < len(h.buckets) {
+            stats.Buckets[i].Count = h.buckets[i]
+        }
+    }
+    stats.Buckets = append(stats.Buckets, LatencyBucket{UpperMS: math.Inf(1)})
+    if h.count == 0 {
+        return stats
+    }
+    stats.Buckets[len(LatencyBuckets)].Count = h.buckets[len(h.buckets)-1]
+    stats.P50 = h.percentile(50)
+    stats.P90 = h.percentile(90)
+    stats.P99 = h.percentile(99)
+    return stats
+}
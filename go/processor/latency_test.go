@@ -0,0 +1,75 @@
+package processor
+
+import (
+    "math"
+    "math/rand"
+    "sort"
+    "testing"
+)
+
+func TestLatencyPercentilesWithinPrecision(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    var whole, left, right Summary
+    var exact []float64
+    for i := 0; i < 100000; i++ {
+        r := Result{TaskID: i + 1, DelayMS: 200 + rng.Int63n(300), ProcessMS: rng.Float64() * 5}
+        whole.Add(r)
+        if i%2 == 0 {
+            left.Add(r)
+        } else {
+            right.Add(r)
+        }
+        exact = append(exact, resultLatency(r))
+    }
+    sort.Float64s(exact)
+    left.merge(right)
+
+    if n := len(whole.latency.fine); n > 1000 {
+        t.Errorf("histogram has %d fine buckets for 100000 results, want it bounded by the spread", n)
+    }
+    for name, s := range map[string]Summary{"added": whole, "merged": left} {
+        stats := s.Latency()
+        if stats.Count != len(exact) {
+            t.Errorf("%s: Count = %d, want %d", name, stats.Count, len(exact))
+        }
+        total := 0
+        for i, b := range stats.Buckets {
+            lower := math.Inf(-1)
+            if i > 0 {
+                lower = stats.Buckets[i-1].UpperMS
+            }
+            want := 0
+            for _, ms := range exact {
+                if ms > lower && ms <= b.UpperMS {
+                    want++
+                }
+            }
+            if b.Count != want {
+                t.Errorf("%s: bucket <=%v has %d tasks, want %d", name, b.UpperMS, b.Count, want)
+            }
+            total += b.Count
+        }
+        if total != len(exact) {
+            t.Errorf("%s: buckets count %d tasks, want %d", name, total, len(exact))
+        }
+        for _, tt := range []struct {
+            p   float64
+            got float64
+        }{{50, stats.P50}, {90, stats.P90}, {99, stats.P99}} {
+            want := exact[int(math.Ceil(tt.p/100*float64(len(exact))))-1]
+            if math.Abs(tt.got-want) > want*latencyPrecision {
+                t.Errorf("%s: p%v = %.3f, want within 1%% of %.3f", name, tt.p, tt.got, want)
+            }
+        }
+    }
+}
+
+func TestLatencyEmptyAndZero(t *testing.T) {
+    if stats := (Summary{}).Latency(); stats.Count != 0 || stats.P99 != 0 || len(stats.Buckets) != len(LatencyBuckets)+1 {
+        t.Errorf("empty summary latency = %+v", stats)
+    }
+    stats := Summarize([]Result{{TaskID: 1}, {TaskID: 2}}).Latency()
+    if stats.P50 != 0 || stats.P99 != 0 || stats.Buckets[0].Count != 2 {
+        t.Errorf("zero latencies = %+v, want p50 and p99 of 0 in the first bucket", stats)
+    }
+}
@@ -0,0 +1,104 @@
+package processor
+
+import (
+    "fmt"
+    "io"
+    "net/http"
+    "sync/atomic"
+    "time"
+)
+
+// Metrics holds live counters for a run, updated atomically by the
+// producer, the collectors and the workers so that they can be read,
+// e.g. scraped over HTTP, while the run is in progress.
+type Metrics struct {
+    submitted atomic.Int64
+    completed atomic.Int64
+    failed    atomic.Int64
+    workers   atomic.Int64
+    blocked   atomic.Int64 // nanoseconds
+}
+
+// Submitted returns how many tasks have been handed to the workers.
+func (m *Metrics) Submitted() int64 { return m.submitted.Load() }
+
+// Completed returns how many tasks have produced a Result.
+func (m *Metrics) Completed() int64 { return m.completed.Load() }
+
+// Failed returns how many tasks have failed after all retries.
+func (m *Metrics) Failed() int64 { return m.failed.Load() }
+
+// Workers returns the number of workers currently running.
+func (m *Metrics) Workers() int64 { return m.workers.Load() }
+
+// ProducerBlocked returns how long the producer has spent waiting for
+// a worker to take a task.
+func (m *Metrics) ProducerBlocked() time.Duration { return time.Duration(m.blocked.Load()) }
+
+// WriteText writes the counters to w in the Prometheus text
+// exposition format.
+func (m *Metrics) WriteText(w io.Writer) error {
+    _, err := fmt.Fprintf(w, `# HELP dps_tasks_submitted_total Tasks handed to the workers.
+# TYPE dps_tasks_submitted_total counter
+dps_tasks_submitted_total %d
+# HELP dps_tasks_completed_total Tasks that produced a result.
+# TYPE dps_tasks_completed_total counter
+dps_tasks_completed_total %d
+# HELP dps_tasks_failed_total Tasks that failed after all retries.
+# TYPE dps_tasks_failed_total counter
+dps_tasks_failed_total %d
+# HELP dps_workers Workers currently running.
+# TYPE dps_workers gauge
+dps_workers %d
+# HELP dps_producer_blocked_seconds_total Time the producer spent waiting for a free worker.
+# TYPE dps_producer_blocked_seconds_total counter
+dps_producer_blocked_seconds_total %g
+`, m.Submitted(), m.Completed(), m.Failed(), m.Workers(), m.ProducerBlocked().Seconds())
+    return err
+}
+
+// ServeHTTP serves the counters in the Prometheus text format, so a
+// *Metrics can be mounted directly as a /metrics handler.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    m.WriteText(w)
+}
+
+// The update methods are no-ops on a nil *Metrics, so the pool can
+// call them unconditionally.
+
+func (m *Metrics) submit(n int) {
+    if m != nil {
+        m.submitted.Add(int64(n))
+    }
+}
+
+func (m *Metrics) complete() {
+    if m != nil {
+        m.completed.Add(1)
+    }
+}
+
+func (m *Metrics) fail() {
+    if m != nil {
+        m.failed.Add(1)
+    }
+}
+
+func (m *Metrics) workerStarted() {
+    if m != nil {
+        m.workers.Add(1)
+    }
+}
+
+func (m *Metrics) workerStopped() {
+    if m != nil {
+        m.workers.Add(-1)
+    }
+}
+
+func (m *Metrics) producerBlocked(d time.Duration) {
+    if m != nil {
+        m.blocked.Add(int64(d))
+    }
+}
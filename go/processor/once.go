@@ -0,0 +1,66 @@
+package processor
+
+import "sync"
+
+// onceGuard enforces Config.ExactlyOnce across the workers. A task ID
+// is claimed before its task is processed and either marked done, if
+// the task succeeded, or released again, so a later task with the same
+// ID can try. A claim on an ID that is in flight elsewhere waits for
+// that attempt to finish. Methods on a nil *onceGuard let every task
+// through.
+type onceGuard struct {
+    mu       sync.Mutex
+    changed  *sync.Cond
+    inFlight map[int]bool
+    done     map[int]bool
+    repeated int
+}
+
+func newOnceGuard() *onceGuard {
+    g := &onceGuard{inFlight: make(map[int]bool), done: make(map[int]bool)}
+    g.changed = sync.NewCond(&g.mu)
+    return g
+}
+
+// claim reports whether the task with this ID should be processed:
+// false if one with the same ID has already succeeded.
+func (g *onceGuard) claim(id int) bool {
+    if g == nil {
+        return true
+    }
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    for g.inFlight[id] {
+        g.changed.Wait()
+    }
+    if g.done[id] {
+        g.repeated++
+        return false
+    }
+    g.inFlight[id] = true
+    return true
+}
+
+// finish ends the claim on id, recording whether the task succeeded.
+func (g *onceGuard) finish(id int, succeeded bool) {
+    if g == nil {
+        return
+    }
+    g.mu.Lock()
+    delete(g.inFlight, id)
+    if succeeded {
+        g.done[id] = true
+    }
+    g.mu.Unlock()
+    g.changed.Broadcast()
+}
+
+// repeats returns how many tasks were skipped as already done.
+func (g *onceGuard) repeats() int {
+    if g == nil {
+        return 0
+    }
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    return g.repeated
+}
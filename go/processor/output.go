@@ -0,0 +1,508 @@
+package processor
+
+import (
+    "bufio"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// Encoders maps each supported output format name to the function
+// that encodes results in that format. It is shared by every run in
+// the process and is not meant to be modified: to change the text
+// line, set the Template of a FileWriter or StdoutWriter instead.
+var Encoders = map[string]func(w io.Writer, results []Result) error{
+    "text": EncodeText,
+    "json": EncodeJSON,
+    "csv":  EncodeCSV,
+}
+
+// Delimiters maps the names of the supported text record delimiters
+// to the delimiters themselves: a newline, a Windows line ending, or a
+// NUL byte for "xargs -0" and similar consumers. The Delimiter of a
+// FileWriter or StdoutWriter picks one.
+var Delimiters = map[string]string{
+    "lf":   "\n",
+    "crlf": "\r\n",
+    "nul":  "\x00",
+}
+
+// EncodeText writes one human-readable line per result to w, each
+// ended by a newline.
+func EncodeText(w io.Writer, results []Result) error {
+    return textEncoder("\n", false)(w, results)
+}
+
+// textEncoder is EncodeText with each line ended by delimiter, and
+// with deterministic set the line without the scheduling fields.
+func textEncoder(delimiter string, deterministic bool) func(w io.Writer, results []Result) error {
+    return func(w io.Writer, results []Result) error {
+        for _, result := range results {
+            if _, err := io.WriteString(w, result.line(deterministic)+delimiter); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+}
+
+// EncodeJSON writes the results to w as an indented JSON array.
+func EncodeJSON(w io.Writer, results []Result) error {
+    return jsonEncoder(false)(w, results)
+}
+
+// jsonEncoder is EncodeJSON, leaving out the scheduling fields if
+// deterministic is set.
+func jsonEncoder(deterministic bool) func(w io.Writer, results []Result) error {
+    return func(w io.Writer, results []Result) error {
+        values := make([]any, len(results))
+        for i, result := range results {
+            values[i] = jsonResult(result, deterministic)
+        }
+        data, err := json.MarshalIndent(values, "", "  ")
+        if err != nil {
+            return err
+        }
+
+        _, err = w.Write(append(data, '\n'))
+        return err
+    }
+}
+
+// deterministicResult marshals as a Result without the fields that
+// depend on scheduling and timing: its nil fields hide Result's under
+// the same names and are omitted.
+type deterministicResult struct {
+    Result
+    WorkerID   *struct{} `json:"worker_id,omitempty"`
+    DelayMS    *struct{} `json:"delay_ms,omitempty"`
+    ProcessMS  *struct{} `json:"process_ms,omitempty"`
+    StartedAt  *struct{} `json:"started_at,omitempty"`
+    FinishedAt *struct{} `json:"finished_at,omitempty"`
+}
+
+// jsonResult is the value to marshal for r: r itself, or if
+// deterministic a deterministicResult.
+func jsonResult(r Result, deterministic bool) any {
+    if deterministic {
+        return deterministicResult{Result: r}
+    }
+    return r
+}
+
+// EncodeCSV writes the results to w as CSV with a header row.
+// encoding/csv takes care of quoting fields that contain commas,
+// quotes, or newlines.
+func EncodeCSV(w io.Writer, results []Result) error {
+    return csvEncoder(false)(w, results)
+}
+
+// csvEncoder is EncodeCSV, leaving the scheduling fields' cells empty
+// if deterministic is set.
+func csvEncoder(deterministic bool) func(w io.Writer, results []Result) error {
+    return func(w io.Writer, results []Result) error {
+        writer := csv.NewWriter(w)
+        if err := writer.Write(csvHeader); err != nil {
+            return err
+        }
+        for _, result := range results {
+            if err := writer.Write(csvRecord(result, deterministic)); err != nil {
+                return err
+            }
+        }
+
+        writer.Flush()
+        return writer.Error()
+    }
+}
+
+// csvHeader names the columns written by EncodeCSV.
+var csvHeader = []string{"worker_id", "task_id", "seq", "input", "output", "transform", "length", "delay_ms", "process_ms", "retries", "source", "sha256", "sub_index", "started_at", "finished_at", "tags"}
+
+// csvRecord formats one result as a CSV row matching csvHeader, with
+// empty worker_id, delay_ms, process_ms, started_at and finished_at
+// cells if deterministic.
+func csvRecord(result Result, deterministic bool) []string {
+    workerID := strconv.Itoa(result.WorkerID)
+    delayMS := strconv.FormatInt(result.DelayMS, 10)
+    processMS := strconv.FormatFloat(result.ProcessMS, 'f', 3, 64)
+    if deterministic {
+        workerID, delayMS, processMS = "", "", ""
+        result.StartedAt, result.FinishedAt = time.Time{}, time.Time{}
+    }
+    return []string{
+        workerID,
+        strconv.Itoa(result.TaskID),
+        strconv.Itoa(result.Seq),
+        result.Input,
+        result.Output,
+        result.Transform,
+        strconv.Itoa(result.Length),
+        delayMS,
+        processMS,
+        strconv.Itoa(result.Retries),
+        result.Source,
+        result.SHA256,
+        strconv.Itoa(result.SubIndex),
+        csvTime(result.StartedAt),
+        csvTime(result.FinishedAt),
+        csvTags(result.Tags),
+    }
+}
+
+// csvTime formats t for a CSV cell as JSON does, in RFC 3339 with
+// nanoseconds, leaving the zero time empty.
+func csvTime(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return t.Format(time.RFC3339Nano)
+}
+
+// csvTags formats tags for a CSV cell as a JSON object, which keeps
+// any key or value intact, leaving no tags empty.
+func csvTags(tags map[string]string) string {
+    if len(tags) == 0 {
+        return ""
+    }
+    data, _ := json.Marshal(tags)
+    return string(data)
+}
+
+// writeFile creates filename and writes the results to it with
+// encode, through a buffered writer, under a temporary name unless
+// inPlace. A filename ending in ".gz" is written gzip-compressed.
+func writeFile(filename string, encode func(io.Writer, []Result) error, results []Result, inPlace bool) error {
+    file, err := createFile(filename, inPlace)
+    if err != nil {
+        return err
+    }
+
+    writer := bufio.NewWriter(file)
+    if err := encode(writer, results); err != nil {
+        discardFile(file)
+        return err
+    }
+
+    if err := writer.Flush(); err != nil {
+        discardFile(file)
+        return err
+    }
+
+    // Closing finishes a gzip stream, so its error matters.
+    return file.Close()
+}
+
+// WriteResultsToFile writes all result lines to the given file,
+// one line per result. It demonstrates Go-style error handling:
+// functions return 'error' and the caller checks 'if err != nil'.
+func WriteResultsToFile(filename string, results []Result) error {
+    return writeFile(filename, EncodeText, results, false)
+}
+
+// WriteResultsJSON writes the results to the given file as an
+// indented JSON array.
+func WriteResultsJSON(filename string, results []Result) error {
+    return writeFile(filename, EncodeJSON, results, false)
+}
+
+// WriteResultsCSV writes the results to the given file as CSV with a
+// header row.
+func WriteResultsCSV(filename string, results []Result) error {
+    return writeFile(filename, EncodeCSV, results, false)
+}
+
+// WriteResultsStream is the channel-based counterpart of
+// WriteResultsToFile: it creates filename and writes each result from
+// the channel, encoded as format (one of the keys of Encoders; empty
+// means "text"), as soon as it arrives, flushing after every result so
+// that a crash loses at most the one in flight. It returns the number
+// of results written once the channel is closed. After a write error
+// it keeps draining the channel, so the sender is never blocked, and
+// returns the first error.
+func WriteResultsStream(filename, format string, results <-chan Result) (int, error) {
+    return FileWriter{Path: filename, Format: format}.WriteStream(results)
+}
+
+// WriteResultsStreamTee is WriteResultsStream that also writes every
+// result, in the same encoding, to tee as it arrives, e.g. to follow a
+// run on os.Stdout while keeping the file. A nil tee writes the file
+// only.
+func WriteResultsStreamTee(filename, format string, tee io.Writer, results <-chan Result) (int, error) {
+    return FileWriter{Path: filename, Format: format}.WriteStreamTee(tee, results)
+}
+
+// AppendResultsStream is WriteResultsStream for an existing file: the
+// results are added to the end of filename, which is created if it
+// does not exist. For CSV the header row is only written to an empty
+// file. JSON output is a single array and cannot be appended to.
+func AppendResultsStream(filename, format string, results <-chan Result) (int, error) {
+    return FileWriter{Path: filename, Format: format, Append: true}.WriteStream(results)
+}
+
+// AppendResultsStreamTee is AppendResultsStream with a tee, as for
+// WriteResultsStreamTee. Without a CSV header in the file, tee gets
+// none either.
+func AppendResultsStreamTee(filename, format string, tee io.Writer, results <-chan Result) (int, error) {
+    return FileWriter{Path: filename, Format: format, Append: true}.WriteStreamTee(tee, results)
+}
+
+// AppendResults adds results to the end of filename, encoded as format,
+// creating the file if it does not exist, so that successive runs
+// accumulate in one file. Text and CSV can be appended to, and a CSV
+// header row is only written to an empty file; JSON output is a single
+// array and cannot be. A filename ending in ".gz" gets a new gzip
+// member, which readers see as a continuation of the same stream.
+func AppendResults(filename, format string, results []Result) error {
+    return appendResults(filename, encoding{format: format}, results)
+}
+
+// appendResults implements AppendResults and FileWriter.Write with
+// Append.
+func appendResults(filename string, enc encoding, results []Result) error {
+    file, continuing, err := openAppend(filename, enc.format)
+    if err != nil {
+        return err
+    }
+
+    buffered := bufio.NewWriter(file)
+    encode, finish, err := newStreamEncoder(buffered, enc, continuing)
+    for i := 0; err == nil && i < len(results); i++ {
+        err = encode(results[i])
+    }
+    if err == nil {
+        err = finish()
+    }
+    if err == nil {
+        err = buffered.Flush()
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// openAppend opens filename for appending results encoded as format,
+// reporting whether it already holds some (so a CSV header must not be
+// repeated). JSON is refused.
+func openAppend(filename, format string) (io.WriteCloser, bool, error) {
+    if format == "json" {
+        return nil, false, errors.New("processor: cannot append to JSON output")
+    }
+    continuing := false
+    if info, err := os.Stat(filename); err == nil {
+        continuing = info.Size() > 0
+    }
+    file, err := openOutput(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+    if err != nil {
+        return nil, false, err
+    }
+    return file, continuing, nil
+}
+
+// teeWriter writes to every one of its writers, like io.MultiWriter,
+// and its Flush flushes those that hold data back, such as a gzip
+// output, so that streamResults pushes each result through all of
+// them.
+type teeWriter []io.Writer
+
+// Write implements io.Writer.
+func (t teeWriter) Write(p []byte) (int, error) {
+    for _, w := range t {
+        if _, err := w.Write(p); err != nil {
+            return 0, err
+        }
+    }
+    return len(p), nil
+}
+
+// Flush flushes the writers that can be flushed.
+func (t teeWriter) Flush() error {
+    for _, w := range t {
+        if flusher, ok := w.(interface{ Flush() error }); ok {
+            if err := flusher.Flush(); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// teeTo returns file, or file and tee together if tee is set.
+func teeTo(file io.Writer, tee io.Writer) io.Writer {
+    if tee == nil {
+        return file
+    }
+    return teeWriter{file, tee}
+}
+
+// StreamResults is WriteResultsStream for an arbitrary io.Writer, such
+// as os.Stdout.
+func StreamResults(w io.Writer, format string, results <-chan Result) (int, error) {
+    return streamResults(w, encoding{format: format}, results, false, nil)
+}
+
+// streamResults implements StreamResults and the writers' WriteStream;
+// continuing leaves out the CSV header, for output appended after
+// earlier rows. written, if set, is called after each result is
+// flushed.
+func streamResults(w io.Writer, enc encoding, results <-chan Result, continuing bool, written func(Result)) (int, error) {
+    buffered := bufio.NewWriter(w)
+    encode, finish, err := newStreamEncoder(buffered, enc, continuing)
+    if err != nil {
+        drain(results)
+        return 0, err
+    }
+
+    // A gzip writer holds data back too; flush it through as well.
+    flusher, _ := w.(interface{ Flush() error })
+    n := 0
+    for result := range results {
+        if err = encode(result); err == nil {
+            err = buffered.Flush()
+        }
+        if err == nil && flusher != nil {
+            err = flusher.Flush()
+        }
+        if err != nil {
+            drain(results)
+            return n, err
+        }
+        n++
+        if written != nil {
+            written(result)
+        }
+    }
+
+    if err := finish(); err != nil {
+        return n, err
+    }
+    return n, buffered.Flush()
+}
+
+// newStreamEncoder returns per-result and end-of-stream functions that
+// produce the same output as enc's encoder, without the CSV header
+// when continuing.
+func newStreamEncoder(w io.Writer, enc encoding, continuing bool) (encode func(Result) error, finish func() error, err error) {
+    switch enc.format {
+    case "", "text":
+        // Text is one line per result, so its encoder (which may be a
+        // TemplateEncoder) can be used result by result.
+        text, err := enc.encoder()
+        if err != nil {
+            return nil, nil, err
+        }
+        encode = func(result Result) error {
+            return text(w, []Result{result})
+        }
+        return encode, func() error { return nil }, nil
+
+    case "json":
+        count := 0
+        encode = func(result Result) error {
+            data, err := json.MarshalIndent(jsonResult(result, enc.deterministic), "  ", "  ")
+            if err != nil {
+                return err
+            }
+            sep := ",\n  "
+            if count == 0 {
+                sep = "[\n  "
+            }
+            count++
+            _, err = io.WriteString(w, sep+string(data))
+            return err
+        }
+        finish = func() error {
+            end := "\n]\n"
+            if count == 0 {
+                end = "[]\n"
+            }
+            _, err := io.WriteString(w, end)
+            return err
+        }
+        return encode, finish, nil
+
+    case "csv":
+        writer := csv.NewWriter(w)
+        if !continuing {
+            if err := writer.Write(csvHeader); err != nil {
+                return nil, nil, err
+            }
+        }
+        encode = func(result Result) error {
+            if err := writer.Write(csvRecord(result, enc.deterministic)); err != nil {
+                return err
+            }
+            writer.Flush()
+            return writer.Error()
+        }
+        finish = func() error {
+            writer.Flush()
+            return writer.Error()
+        }
+        return encode, finish, nil
+    }
+    return nil, nil, fmt.Errorf("processor: unknown output format %q", enc.format)
+}
+
+// drain discards everything left on results until it is closed.
+func drain(results <-chan Result) {
+    for range results {
+    }
+}
+
+// AppendSummary appends summary, preceded by a blank line, to the
+// given file, typically a text results file that was just written.
+func AppendSummary(filename string, summary Summary) error {
+    file, err := openOutput(filename, os.O_APPEND|os.O_WRONLY)
+    if err != nil {
+        return err
+    }
+    if _, err := fmt.Fprintf(file, "\n%s", summary); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+// WriteFailedTasks writes the ID and original data of every failed
+// task to the given file, one tab-separated "<id>\t<data>" line per
+// task, sorted by ID. The data column can be fed back in as input
+// (e.g. with "cut -f2-") to re-run just the failed subset.
+func WriteFailedTasks(filename string, failed []Task) error {
+    return FileWriter{Path: filename}.WriteFailedTasks(failed)
+}
+
+// WriteFailedTasks is the package-level WriteFailedTasks into w.Path,
+// honouring w.InPlace; the format settings do not apply.
+func (w FileWriter) WriteFailedTasks(failed []Task) error {
+    sorted := append([]Task(nil), failed...)
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i].ID < sorted[j].ID
+    })
+
+    file, err := createFile(w.Path, w.InPlace)
+    if err != nil {
+        return err
+    }
+
+    writer := bufio.NewWriter(file)
+    for _, task := range sorted {
+        if _, err := fmt.Fprintf(writer, "%d\t%s\n", task.ID, task.Data); err != nil {
+            discardFile(file)
+            return err
+        }
+    }
+
+    if err := writer.Flush(); err != nil {
+        discardFile(file)
+        return err
+    }
+    return file.Close()
+}
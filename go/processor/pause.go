@@ -0,0 +1,68 @@
+package processor
+
+import (
+    "context"
+    "log/slog"
+    "sync"
+)
+
+// pauseGate holds the workers back while the run is paused through
+// Config.Pause. A worker calls wait before starting each task, so the
+// tasks already in progress finish and no new one starts until the run
+// is resumed. Methods on a nil *pauseGate never wait.
+type pauseGate struct {
+    mu sync.Mutex
+    // resumed is nil while running; while paused it is closed on
+    // resume.
+    resumed chan struct{}
+}
+
+// listen pauses the gate for every true received on requests and
+// resumes it for every false, until requests is closed or ctx is done.
+// A run is never left paused once listen returns.
+func (g *pauseGate) listen(ctx context.Context, requests <-chan bool, log *slog.Logger) {
+    defer g.set(false, log)
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case pause, ok := <-requests:
+            if !ok {
+                return
+            }
+            g.set(pause, log)
+        }
+    }
+}
+
+// set pauses or resumes the gate; a repeated request changes nothing.
+func (g *pauseGate) set(pause bool, log *slog.Logger) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    switch {
+    case pause && g.resumed == nil:
+        g.resumed = make(chan struct{})
+        log.Info("paused: tasks in progress finish, no new ones start")
+    case !pause && g.resumed != nil:
+        close(g.resumed)
+        g.resumed = nil
+        log.Info("resumed")
+    }
+}
+
+// wait blocks while the gate is paused, or until ctx is done.
+func (g *pauseGate) wait(ctx context.Context) {
+    if g == nil {
+        return
+    }
+    g.mu.Lock()
+    resumed := g.resumed
+    g.mu.Unlock()
+    if resumed == nil {
+        return
+    }
+    select {
+    case <-resumed:
+    case <-ctx.Done():
+    }
+}
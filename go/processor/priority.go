@@ -0,0 +1,54 @@
+package processor
+
+import "container/heap"
+
+// taskHeap is a max-heap of tasks ordered by Priority. Tasks with equal
+// priority come out in the order they were pushed, so a run where every
+// task has the same priority dispatches them unchanged.
+type taskHeap struct {
+    items []heapItem
+    next  int
+}
+
+// heapItem is a task plus its push order, used to break ties.
+type heapItem struct {
+    task  Task
+    order int
+}
+
+func (h *taskHeap) Len() int { return len(h.items) }
+
+func (h *taskHeap) Less(i, j int) bool {
+    a, b := h.items[i], h.items[j]
+    if a.task.Priority != b.task.Priority {
+        return a.task.Priority > b.task.Priority
+    }
+    return a.order < b.order
+}
+
+func (h *taskHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *taskHeap) Push(x any) {
+    h.items = append(h.items, heapItem{task: x.(Task), order: h.next})
+    h.next++
+}
+
+func (h *taskHeap) Pop() any {
+    last := h.items[len(h.items)-1]
+    h.items = h.items[:len(h.items)-1]
+    return last.task
+}
+
+// newTaskHeap builds a heap holding all of taskList.
+func newTaskHeap(taskList []Task) *taskHeap {
+    h := &taskHeap{}
+    for _, task := range taskList {
+        heap.Push(h, task)
+    }
+    return h
+}
+
+// popTask removes and returns the highest-priority task.
+func (h *taskHeap) popTask() Task {
+    return heap.Pop(h).(Task)
+}
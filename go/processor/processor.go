@@ -0,0 +1,1197 @@
+package processor
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math/rand"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+    "unicode/utf8"
+)
+
+// Config describes a single run of the worker pool.
+type Config struct {
+    // Context cancels the run early; nil means context.Background().
+    Context context.Context
+
+    // Tasks are the tasks to process. They are dispatched highest
+    // Priority first; tasks of equal priority keep their order here.
+    Tasks []Task
+
+    // Stream, if set, supplies the tasks instead of Tasks: the producer
+    // dispatches them as they arrive, in arrival order (Priority is not
+    // honoured, since the full set is never known), until the channel
+    // is closed. See StreamTasks.
+    Stream <-chan Task
+
+    // Workers is the number of worker goroutines; it must be positive.
+    Workers int
+
+    // BufferSize is the capacity of the task channel. With 0 every
+    // send blocks until a worker is ready, so the producer can never
+    // get ahead of the pool. A larger buffer lets a bursty producer
+    // queue up to that many tasks before it blocks; backpressure still
+    // applies once the buffer is full, and tasks sitting in the buffer
+    // are dropped if the run is cancelled.
+    BufferSize int
+
+    // Dispatch selects how tasks reach the workers. "shared" (or empty)
+    // has every worker take its next task from one shared channel, so
+    // whichever worker is free takes it. "roundrobin" gives each
+    // worker a channel of its own, with BufferSize room, and deals the
+    // tasks out to them in turn, so every worker gets the same number
+    // of tasks however long they take, and a slow task holds up the
+    // ones queued behind it. Round-robin is not available with
+    // autoscaling, batching or IdleTimeout.
+    Dispatch string
+
+    // MaxWorkers enables autoscaling when greater than Workers: a
+    // supervisor adds workers, up to MaxWorkers, while the buffered
+    // task channel is at least half full. Autoscaled workers exit after
+    // ScaleIdleTimeout without a task (default one second); the
+    // original Workers stay until the run ends. Autoscaling needs a
+    // positive BufferSize and is not available in batch mode.
+    MaxWorkers       int
+    ScaleIdleTimeout time.Duration
+
+    // MaxInFlight, if positive and below the number of workers, caps
+    // how many tasks are processed at the same time, for transforms
+    // that hold a scarce resource such as an open file: a worker waits
+    // for one of MaxInFlight slots before working on a task, simulated
+    // delay and retries included, and frees it when done. The workers
+    // still take tasks from the queue as before. 0 means no cap beyond
+    // the workers themselves. With RunStages each stage has its own cap.
+    MaxInFlight int
+
+    // Decode, if set, decodes each task's data before the transform
+    // sees it, e.g. Decoders["base64"]; a task whose data does not
+    // decode fails as ErrInvalidInput without retries. Filter and
+    // MaxDataLen apply to the data as loaded, before decoding.
+    Decode Decoder
+
+    // Transform is applied to each task's data; nil means
+    // strings.ToUpper. A Pipeline's Transform method builds one from a
+    // chain of built-in transforms.
+    Transform Transform
+
+    // FanOut, if set, splits each output of Transform into any number
+    // of results, one per part, numbered by Result.SubIndex and sharing
+    // the task's ID, input and timings; a task split into no parts
+    // produces no result. Summary.Tasks then counts results.
+    FanOut FanOut
+
+    // TransformName is recorded in every Result; it defaults to
+    // "upper" when Transform is nil.
+    TransformName string
+
+    // Checksums records the SHA-256 of every output in Result.SHA256;
+    // see Worker.Checksum.
+    Checksums bool
+
+    // MaxRetries is how many times a failing task is retried, waiting
+    // as Backoff says between attempts.
+    MaxRetries int
+    Backoff    Backoff
+
+    // TaskTimeout bounds each task's processing; see Worker.TaskTimeout.
+    TaskTimeout time.Duration
+
+    // IdleTimeout makes each of the Workers exit once it has waited
+    // this long without a task; 0 means wait until the tasks run out.
+    // Once every worker has gone, the pool stops taking tasks and the
+    // run ends without error, with Report.Idle set. It is meant for a
+    // Stream whose producer may go quiet without closing it. It is
+    // ignored by a Sequential run.
+    IdleTimeout time.Duration
+
+    // Budget, if positive, is how long the run may keep starting
+    // tasks. Once it is spent the producer stops and the tasks already
+    // handed to the workers, including any in the buffer, are finished
+    // and collected as usual; the rest are left undone. The run ends
+    // without error and Report.BudgetSpent is set. Unlike cancelling
+    // the Context, no task is abandoned part-way.
+    Budget time.Duration
+
+    // Drain changes what cancelling the Context does: like a spent
+    // Budget, it stops the producer, and the tasks already handed to
+    // the workers, including any in the buffer, are finished and
+    // collected rather than abandoned. Report.Drained is then set, and
+    // the Context's error is still returned. A TaskTimeout, FailFast
+    // and the failure limits still abandon tasks. Drain is not
+    // supported by RunStages.
+    Drain bool
+
+    // Seed seeds the per-worker random sources for the simulated delay.
+    Seed int64
+
+    // NoDelay turns off the simulated delay; see Worker.NoDelay.
+    NoDelay bool
+
+    // BatchSize switches the pool to batch mode when positive: the
+    // producer groups tasks into slices of up to BatchSize and workers
+    // pull a whole batch at a time, amortizing the per-task channel and
+    // logging overhead. 0 sends tasks one by one.
+    BatchSize int
+
+    // Filter, if set, excludes tasks whose Data does not match it;
+    // they are never dispatched and are counted in Summary.Filtered.
+    Filter *regexp.Regexp
+
+    // MaxDataLen, if positive, caps the length of a task's Data in
+    // characters. Tasks over the limit are dealt with before they are
+    // dispatched, according to OnOversize: "truncate" (the default)
+    // cuts the data to MaxDataLen characters and logs a warning, and
+    // "reject" records the task as a Failure of kind ErrInvalidInput
+    // without processing it.
+    MaxDataLen int
+    OnOversize string
+
+    // Checkpoint, if set, records each completed task ID and skips
+    // tasks it already holds as done, so that a run can be resumed
+    // after a crash; see OpenCheckpoint. It is saved periodically and
+    // when the run ends. Streamed results are left for the receiver
+    // of Results to Mark once it has stored them.
+    Checkpoint *Checkpoint
+
+    // Rate caps how many tasks per second the producer dispatches,
+    // regardless of how many workers are idle; 0 means unlimited.
+    Rate float64
+
+    // Ordered sorts the results by task ID instead of completion order.
+    Ordered bool
+
+    // ExactlyOnce guarantees that no task ID is processed successfully
+    // more than once in a run, which matters when the transform has
+    // external side effects. Workers track the IDs of completed tasks
+    // and skip any later task with the same ID (counted in
+    // Report.Repeated), for instance a repeat in the input. A second
+    // task with an ID that is still in flight waits for the first to
+    // finish, so a failed attempt can still be retried by a repeat.
+    // The cost is a lock taken twice per task and a set that grows with
+    // the number of tasks. The default, at-least-once, processes every
+    // task it is given. Across a crash the guarantee is only as good as
+    // the Checkpoint, which is saved periodically: tasks completed
+    // after its last save are processed again on resume.
+    ExactlyOnce bool
+
+    // FailFast stops the run at the first failed task: the workers'
+    // context is cancelled, tasks still in flight are abandoned, and
+    // RunReport returns an error wrapping ErrFailFast and the Failure
+    // alongside the results collected so far. Otherwise failures are
+    // collected and the run carries on.
+    FailFast bool
+
+    // MaxFailures and MaxFailureRate stop the run, like FailFast but
+    // with some tolerance, once failures show a systemic problem rather
+    // than a few bad records: when more than MaxFailures tasks have
+    // failed, or when failures make up more than MaxFailureRate (a
+    // fraction between 0 and 1) of the tasks finished so far, counted
+    // from the minFailureRateTasks-th finished task on so that one early
+    // failure does not count as 100%. RunReport then returns an error
+    // wrapping ErrTooManyFailures. 0 disables either check.
+    MaxFailures    int
+    MaxFailureRate float64
+
+    // Cancel, if set, carries the IDs of tasks to cancel individually
+    // while the run goes on: a task with that ID that is being
+    // processed is abandoned, and otherwise the next one to start is
+    // skipped. Either way it is recorded as a Failure wrapping
+    // ErrTaskCancelled, and other tasks are unaffected. Requests are
+    // read until the channel is closed or the run ends.
+    Cancel <-chan int
+
+    // Pause, if set, pauses the run on every true received and resumes
+    // it on every false: while paused, tasks already in progress
+    // finish but no worker starts another, and tasks stay queued.
+    // Timeouts and the Budget keep running. Requests are read until the
+    // channel is closed or the run ends, and closing it resumes a
+    // paused run.
+    Pause <-chan bool
+
+    // Snapshot, if set, lets the caller look at the results collected
+    // so far without stopping the run: for each channel received from
+    // Snapshot, RunReport sends a copy of the results in hand, in
+    // completion order, on that channel (which should be buffered).
+    // Requests are read until the channel is closed or the workers
+    // have finished. It cannot be combined with Results, Reducer or
+    // SplitOutput, which do not keep the results.
+    Snapshot <-chan chan<- []Result
+
+    // Sequential processes the tasks one at a time, in dispatch order,
+    // on the goroutine calling RunReport, with a single worker and no
+    // channels; Workers, BufferSize, BatchSize and autoscaling are
+    // ignored. Written with FileWriter.Deterministic, the output is
+    // byte for byte that of an Ordered run with any number of workers,
+    // giving a golden reference that is easy to step through in a
+    // debugger.
+    Sequential bool
+
+    // Results, if set, switches to streaming: each Result is sent on
+    // it as soon as its task completes instead of being kept for
+    // Report.Results, so memory no longer grows with the number of
+    // tasks. RunReport closes the channel once every worker has
+    // finished (or straight away for an invalid config); the caller
+    // must keep receiving until then. Report.Summary
+    // is still filled in. Streaming cannot be combined with Ordered.
+    Results chan<- Result
+
+    // Reducer, if set, is given each Result on the collector goroutine
+    // in place of Report.Results, so memory does not grow with the
+    // number of tasks; Report.Reduced holds what its Finish returns,
+    // also for a run that was cancelled. Report.Summary is still filled
+    // in. It cannot be combined with Results, Ordered or SplitOutput.
+    Reducer Reducer
+
+    // SampleRate, if greater than 1, keeps only one in every SampleRate
+    // tasks' results in Report.Results or on Results: those of the
+    // tasks dispatched 1st, SampleRate+1-th and so on, by Task.Seq, so
+    // the same run always samples the same tasks. Report.Summary still
+    // counts every result, and a Reducer and Checkpoint still see them
+    // all. It cannot be combined with SplitOutput.
+    SampleRate int
+
+    // SplitOutput, if set, gives every worker its own results file
+    // instead of collecting the results in one place: each worker
+    // writes its results, encoded as SplitOutput.Format with its
+    // Template, to SplitOutputPath(SplitOutput.Path, workerID) as they
+    // complete, appending if SplitOutput.Append is set, with no
+    // synchronization between workers. A file is only created once its
+    // worker has a result. Report.Files lists the files and
+    // Report.Results stays empty; Report.Summary is still filled in.
+    // It cannot be combined with Results, Ordered or Checkpoint.
+    SplitOutput *FileWriter
+
+    // OnWorkerStart and OnWorkerStop are set as every worker's OnStart
+    // and OnStop hooks; see Worker. nil means no hook.
+    OnWorkerStart func(workerID int)
+    OnWorkerStop  func(workerID int)
+
+    // Progress, if set, counts finished tasks as the run goes, e.g.
+    // for a progress display; see NewProgress.
+    Progress *Progress
+
+    // ProgressFunc, if set, is called with the numbers of finished and
+    // total tasks (0 for a Stream) as the run goes, to drive a progress
+    // display of the caller's own: at most once per ProgressInterval
+    // (default 100ms), only when the count has changed, and once more
+    // when the workers are done. The calls come from a single
+    // goroutine. It reads Progress, which is created if not set.
+    ProgressFunc     func(done, total int)
+    ProgressInterval time.Duration
+
+    // Metrics, if set, is updated live with the numbers of submitted,
+    // completed and failed tasks and of running workers.
+    Metrics *Metrics
+
+    // Logger receives structured activity records from the producer
+    // and workers; nil discards them.
+    Logger *slog.Logger
+}
+
+// Report is everything a run produced: the results, the tasks that
+// failed after all retries, one WorkerStats per worker, and a Summary
+// reduced from the results.
+type Report struct {
+    Results  []Result
+    Failures []Failure
+    Stats    []WorkerStats
+    Summary  Summary
+
+    // Tasks counts the tasks the run finished, whether they succeeded
+    // or are among Failures. A task split by a FanOut counts once,
+    // where Summary.Tasks counts each of its results.
+    Tasks int
+
+    // Repeated counts the tasks skipped by Config.ExactlyOnce because
+    // a task with the same ID had already been processed.
+    Repeated int
+
+    // ProducerBlocked is the total time the producer spent blocked
+    // sending tasks because no worker was ready to take one. A large
+    // share of the run time means the run is worker-bound.
+    ProducerBlocked time.Duration
+
+    // Files lists the per-worker files written with
+    // Config.SplitOutput, in worker order. Write errors are reported
+    // here rather than as the error from RunReport.
+    Files []OutputFile
+
+    // Reduced is the value returned by Config.Reducer's Finish, or nil
+    // without a Reducer.
+    Reduced any
+
+    // Idle reports that the run ended because every worker went idle
+    // for Config.IdleTimeout before the tasks ran out.
+    Idle bool
+
+    // Drained reports that the Context was cancelled under
+    // Config.Drain, so the run finished the tasks it had already
+    // queued and started no more.
+    Drained bool
+
+    // BudgetSpent reports that Config.Budget ran out before the run was
+    // over, so some tasks may not have been started.
+    BudgetSpent bool
+}
+
+// errWorkersIdle is the cause of the feed's cancellation when every
+// worker has gone idle; see Config.IdleTimeout.
+var errWorkersIdle = errors.New("every worker went idle")
+
+// errBudgetSpent is the cause of the feed's cancellation when
+// Config.Budget runs out.
+var errBudgetSpent = errors.New("time budget spent")
+
+// errDraining is the cause of the feed's cancellation when the Context
+// is cancelled under Config.Drain.
+var errDraining = errors.New("draining after cancellation")
+
+// ErrFailFast is wrapped by the error RunReport returns when
+// Config.FailFast stopped the run.
+var ErrFailFast = errors.New("processor: stopped at first failure")
+
+// ErrTooManyFailures is wrapped by the error RunReport returns when
+// Config.MaxFailures or Config.MaxFailureRate stopped the run.
+var ErrTooManyFailures = errors.New("processor: too many failures")
+
+// minFailureRateTasks is how many tasks must have finished before
+// Config.MaxFailureRate is checked.
+const minFailureRateTasks = 10
+
+// Run processes config.Tasks with a pool of workers and returns the
+// collected results. See RunReport for the error semantics.
+func Run(config Config) ([]Result, error) {
+    report, err := RunReport(config)
+    if report == nil {
+        return nil, err
+    }
+    return report.Results, err
+}
+
+// ProcessTasks runs tasks through a pool configured by config, in
+// place of config.Tasks and config.Stream, and returns the results and
+// the tasks that failed after all retries, in memory, for callers such
+// as tests that want to inspect them without reading a file back.
+// Results come in completion order unless config.Ordered is set. The
+// error is that of RunReport; on an invalid config both slices are
+// nil.
+func ProcessTasks(config Config, tasks []Task) ([]Result, []Task, error) {
+    config.Tasks, config.Stream = tasks, nil
+    report, err := RunReport(config)
+    if report == nil {
+        return nil, nil, err
+    }
+    var failed []Task
+    for _, f := range report.Failures {
+        failed = append(failed, f.Task)
+    }
+    return report.Results, failed, err
+}
+
+// RunReport processes config.Tasks with a pool of workers and returns
+// the full Report. An invalid config returns a nil Report. If the
+// context is cancelled, the Report holds whatever was collected before
+// the workers stopped and the context's error is returned alongside it;
+// the same goes for a run stopped by FailFast.
+func RunReport(config Config) (*Report, error) {
+    if err := config.validate(); err != nil {
+        if config.Results != nil {
+            close(config.Results)
+        }
+        return nil, err
+    }
+
+    ctx := config.Context
+    if ctx == nil {
+        ctx = context.Background()
+    }
+    // Under Drain the workers run on a context the caller cannot
+    // cancel; the caller's only stops the feed, below.
+    callerCtx := ctx
+    if config.Drain {
+        ctx = context.WithoutCancel(ctx)
+    }
+    log := config.Logger
+    if log == nil {
+        log = discardLogger
+    }
+    // MaxFailureRate counts the finished tasks through the metrics.
+    if config.MaxFailureRate > 0 && config.Metrics == nil {
+        config.Metrics = new(Metrics)
+    }
+    if config.ProgressFunc != nil && config.Progress == nil {
+        total := len(config.Tasks)
+        if config.Stream != nil {
+            total = 0
+        }
+        config.Progress = NewProgress(total)
+    }
+    // FailFast and the failure limits cancel this context, with the
+    // reason as its cause.
+    ctx, cancel := context.WithCancelCause(ctx)
+    defer cancel(nil)
+    transform, transformName := config.Transform, config.TransformName
+    if transform == nil {
+        transform, transformName = strings.ToUpper, "upper"
+    }
+
+    // Workers send results over a channel to a single collector
+    // goroutine, so finishing a task never contends on a lock. The
+    // collector builds the results slice or, with a Results channel,
+    // streams each result on to the caller, building the summary on
+    // the way. resultsMu lets Snapshot copy the slice while it grows.
+    var results []Result
+    var resultsMu sync.Mutex
+    var failures []Failure
+    var summary Summary
+    sampled := func(r Result) bool {
+        return config.SampleRate <= 1 || (r.Seq-1)%config.SampleRate == 0
+    }
+    collect := func(r Result) {
+        config.Metrics.complete()
+        switch {
+        case config.Reducer != nil:
+            summary.Add(r)
+            config.Reducer.Add(r)
+        case !sampled(r):
+            summary.Add(r)
+        case config.Results != nil:
+            summary.Add(r)
+            // The receiver marks r in the checkpoint once it has
+            // stored it.
+            config.Results <- r
+            return
+        default:
+            if config.SampleRate > 1 {
+                summary.Add(r)
+            }
+            resultsMu.Lock()
+            results = append(results, r)
+            resultsMu.Unlock()
+        }
+        if config.Checkpoint != nil {
+            config.Checkpoint.Mark(r.TaskID)
+        }
+    }
+    // Failures come from the failures collector and, for oversized
+    // tasks, from the producer.
+    var failuresMu sync.Mutex
+    fail := func(f Failure) {
+        failuresMu.Lock()
+        defer failuresMu.Unlock()
+        config.Metrics.fail()
+        failures = append(failures, f)
+        if config.FailFast && len(failures) == 1 {
+            log.Warn("stopping at first failure", "task_id", f.Task.ID, "error", f.Err)
+            cancel(fmt.Errorf("%w: %w", ErrFailFast, &failures[0]))
+        }
+        if err := config.failureLimit(len(failures)); err != nil && ctx.Err() == nil {
+            log.Warn("stopping: too many failures", "failed", len(failures), "error", err)
+            cancel(err)
+        }
+    }
+
+    stopSaving := make(chan struct{})
+    savingDone := make(chan struct{})
+    if config.Checkpoint != nil {
+        go config.Checkpoint.autosave(stopSaving, savingDone, log)
+    } else {
+        close(savingDone)
+    }
+
+    stopNotify := make(chan struct{})
+    notifyDone := make(chan struct{})
+    if config.ProgressFunc != nil {
+        interval := config.ProgressInterval
+        if interval <= 0 {
+            interval = defaultProgressInterval
+        }
+        go config.Progress.notify(config.ProgressFunc, interval, stopNotify, notifyDone)
+    } else {
+        close(notifyDone)
+    }
+
+    stopSnapshots := make(chan struct{})
+    snapshotsDone := make(chan struct{})
+    if config.Snapshot != nil {
+        go serveSnapshots(config.Snapshot, &resultsMu, &results, stopSnapshots, snapshotsDone)
+    } else {
+        close(snapshotsDone)
+    }
+
+    // The feed has its own context so that the pool can stop taking
+    // tasks once every worker has gone idle or the budget is spent.
+    feedCtx, cancelFeed := context.WithCancelCause(ctx)
+    defer cancelFeed(nil)
+    stopFeed := func() { cancelFeed(errWorkersIdle) }
+    if config.Budget > 0 {
+        budget := time.AfterFunc(config.Budget, func() {
+            log.Info("time budget spent, starting no more tasks", "budget", config.Budget)
+            cancelFeed(errBudgetSpent)
+        })
+        defer budget.Stop()
+    }
+    if config.Drain {
+        stop := context.AfterFunc(callerCtx, func() {
+            log.Info("cancelled, starting no more tasks and finishing the queued ones")
+            cancelFeed(errDraining)
+        })
+        defer stop()
+    }
+    next := config.taskFeed(feedCtx)
+    filtered, resumed := 0, 0
+    if config.Filter != nil {
+        next = skipFeed(next, func(task Task) bool { return !config.Filter.MatchString(task.Data) }, &filtered, config.Progress)
+    }
+    if config.Checkpoint != nil {
+        next = skipFeed(next, func(task Task) bool { return config.Checkpoint.Done(task.ID) }, &resumed, config.Progress)
+    }
+    if config.MaxDataLen > 0 {
+        next = config.limitFeed(next, fail, log)
+    }
+
+    // Each worker gets its own random source derived from the seed,
+    // since *rand.Rand is not safe for concurrent use.
+    var once *onceGuard
+    if config.ExactlyOnce {
+        once = newOnceGuard()
+    }
+    var cancels *cancelRegistry
+    if config.Cancel != nil {
+        cancels = newCancelRegistry()
+        go cancels.listen(ctx, config.Cancel, log)
+    }
+    var pause *pauseGate
+    if config.Pause != nil {
+        pause = new(pauseGate)
+        go pause.listen(ctx, config.Pause, log)
+    }
+    inflight := newInflightLimit(config.MaxInFlight, max(config.Workers, config.MaxWorkers))
+    var workers []*Worker
+    var workersMu sync.Mutex
+    newWorker := func(idleTimeout time.Duration) *Worker {
+        workersMu.Lock()
+        defer workersMu.Unlock()
+        id := len(workers) + 1
+        w := &Worker{
+            ID:            id,
+            Transform:     transform,
+            FanOut:        config.FanOut,
+            Decode:        config.Decode,
+            TransformName: transformName,
+            MaxRetries:    config.MaxRetries,
+            Backoff:       config.Backoff,
+            TaskTimeout:   config.TaskTimeout,
+            IdleTimeout:   idleTimeout,
+            Rand:          rand.New(rand.NewSource(config.Seed + int64(id))),
+            NoDelay:       config.NoDelay,
+            Checksum:      config.Checksums,
+            Progress:      config.Progress,
+            Logger:        config.Logger,
+            OnStart:       config.OnWorkerStart,
+            OnStop:        config.OnWorkerStop,
+            once:          once,
+            cancels:       cancels,
+            pause:         pause,
+            inflight:      inflight,
+        }
+        if config.SplitOutput != nil {
+            w.split = &splitOutput{
+                path:     SplitOutputPath(config.SplitOutput.Path, id),
+                encoding: config.SplitOutput.encoding(),
+                append:   config.SplitOutput.Append,
+            }
+            w.metrics = config.Metrics
+        }
+        workers = append(workers, w)
+        return w
+    }
+
+    var blocked time.Duration
+    var idle bool
+    if config.Sequential {
+        // One worker, driven directly by the producer on this
+        // goroutine: no channels and no other goroutines on the data
+        // path, and the same output as Workers: 1.
+        w := newWorker(0)
+        w.Stats.WorkerID = w.ID
+        config.Metrics.workerStarted()
+        w.start()
+        produce(feedCtx, next, config.Rate, config.Logger, func(task Task) bool {
+            config.Metrics.submit(1)
+            if !once.claim(task.ID) {
+                w.Progress.skip()
+                return true
+            }
+            out, err := w.process(ctx, task)
+            once.finish(task.ID, err == nil)
+            var failure *Failure
+            switch {
+            case errors.As(err, &failure):
+                fail(*failure)
+            case err != nil:
+                return false
+            }
+            for _, result := range out {
+                if w.split != nil {
+                    w.split.write(result)
+                    config.Metrics.complete()
+                } else {
+                    collect(result)
+                }
+            }
+            w.Progress.add()
+            return true
+        })
+        w.stop()
+        config.Metrics.workerStopped()
+    } else {
+        blocked, idle = config.runPool(ctx, feedCtx, stopFeed, next, newWorker, collect, fail)
+    }
+
+    if config.Results != nil {
+        close(config.Results)
+    }
+    close(stopSaving)
+    <-savingDone
+    close(stopSnapshots)
+    <-snapshotsDone
+    close(stopNotify)
+    <-notifyDone
+    if resumed > 0 {
+        log.Info("skipped tasks already done in checkpoint", "count", resumed)
+    }
+    if n := once.repeats(); n > 0 {
+        log.Info("skipped repeated tasks already processed", "count", n)
+    }
+
+    stats := make([]WorkerStats, len(workers))
+    tasks := len(failures)
+    for i, w := range workers {
+        stats[i] = w.Stats
+        tasks += w.Stats.TasksProcessed
+    }
+    files, splitSummary := closeSplitOutputs(workers)
+
+    // Workers append in completion order; sort by task ID if the
+    // caller asked for deterministic output.
+    if config.Ordered {
+        SortResultsByTaskID(results)
+    }
+
+    // Reduce: once every worker is done, fold the results into totals.
+    if config.Results == nil && config.Reducer == nil && config.SampleRate <= 1 {
+        summary = Summarize(results)
+    }
+    summary.merge(splitSummary)
+    summary.Filtered = filtered
+    summary.FailuresByKind = CountFailureKinds(failures)
+
+    budgetSpent := errors.Is(context.Cause(feedCtx), errBudgetSpent) && ctx.Err() == nil
+    drained := errors.Is(context.Cause(feedCtx), errDraining) && ctx.Err() == nil
+    err := context.Cause(ctx)
+    if drained {
+        err = context.Cause(callerCtx)
+    }
+
+    var reduced any
+    if config.Reducer != nil {
+        reduced = config.Reducer.Finish()
+    }
+
+    return &Report{Results: results, Failures: failures, Stats: stats, Summary: summary, Tasks: tasks, Repeated: once.repeats(), ProducerBlocked: blocked, Files: files, Reduced: reduced, Idle: idle, Drained: drained, BudgetSpent: budgetSpent}, err
+}
+
+// closeSplitOutputs closes the workers' split output files, if any,
+// and returns them with the summary of the results written to them.
+func closeSplitOutputs(workers []*Worker) ([]OutputFile, Summary) {
+    var files []OutputFile
+    var summary Summary
+    for _, w := range workers {
+        if w.split == nil {
+            continue
+        }
+        err := w.split.close()
+        summary.merge(w.split.summary)
+        if w.split.file != nil || err != nil {
+            files = append(files, OutputFile{WorkerID: w.ID, Path: w.split.path, Results: w.split.count, Err: err})
+        }
+    }
+    return files, summary
+}
+
+// runPool runs the concurrent pool for RunReport: it starts the
+// workers (and the autoscaler, if enabled), feeds them every task from
+// next, and returns once they have all finished. Results are passed to
+// collect and failures to fail, each from a single collector goroutine.
+// The producer runs under feedCtx, the context of next, and with an
+// IdleTimeout stopFeed cancels it once every worker has exited. It
+// returns how long the producer was blocked on full task channels and
+// whether the run ended that way.
+func (c Config) runPool(ctx, feedCtx context.Context, stopFeed func(), next func() (Task, bool), newWorker func(time.Duration) *Worker, collect func(Result), fail func(Failure)) (time.Duration, bool) {
+    resultsCh := make(chan Result)
+    resultsDone := make(chan struct{})
+    go func() {
+        for r := range resultsCh {
+            collect(r)
+        }
+        close(resultsDone)
+    }()
+
+    // Failed tasks go over their own channel to a collector goroutine
+    failuresCh := make(chan Failure)
+    failuresDone := make(chan struct{})
+    go func() {
+        for f := range failuresCh {
+            fail(f)
+        }
+        close(failuresDone)
+    }()
+
+    // WaitGroup to wait for all workers to finish; spawn adds to it
+    // each time a worker starts, including autoscaled ones.
+    var wg sync.WaitGroup
+
+    // A ChannelQueue acts as our thread-safe task queue; in batch mode
+    // a plain channel carries []Task batches instead. Round-robin
+    // dispatch gives each worker its own queue.
+    queue := NewChannelQueue(ctx, c.BufferSize)
+    batches := make(chan []Task, c.BufferSize)
+    var queues []*ChannelQueue
+    if c.Dispatch == "roundrobin" {
+        queues = make([]*ChannelQueue, c.Workers)
+        for i := range queues {
+            queues[i] = NewChannelQueue(ctx, c.BufferSize)
+        }
+    }
+
+    // Start worker goroutines. The autoscaler may call spawn again
+    // while the producer runs; newWorker guards the workers list.
+    var active atomic.Int32
+    spawn := func(idleTimeout time.Duration) {
+        w := newWorker(idleTimeout)
+        wg.Add(1)
+        active.Add(1)
+        c.Metrics.workerStarted()
+        go func() {
+            defer c.Metrics.workerStopped()
+            defer func() {
+                if active.Add(-1) == 0 && c.IdleTimeout > 0 {
+                    stopFeed()
+                }
+            }()
+            switch {
+            case c.BatchSize > 0:
+                w.runBatches(ctx, batches, resultsCh, failuresCh, &wg)
+            case queues != nil:
+                w.run(ctx, queues[w.ID-1], resultsCh, failuresCh, &wg)
+            default:
+                w.run(ctx, queue, resultsCh, failuresCh, &wg)
+            }
+        }()
+    }
+    for i := 0; i < c.Workers; i++ {
+        spawn(c.IdleTimeout)
+    }
+
+    // Autoscaler: add workers while the backlog is large, up to
+    // MaxWorkers. It is stopped before the task channel is closed so
+    // that no worker is added once wg.Wait may have started.
+    stopScaling := make(chan struct{})
+    scalingDone := make(chan struct{})
+    if c.MaxWorkers > c.Workers {
+        idleTimeout := c.ScaleIdleTimeout
+        if idleTimeout <= 0 {
+            idleTimeout = defaultScaleIdleTimeout
+        }
+        go autoscale(queue, c.MaxWorkers, &active, func() { spawn(idleTimeout) }, stopScaling, scalingDone, c.Logger)
+    } else {
+        close(scalingDone)
+    }
+
+    // Producer: add tasks to the channel in priority order (or as they
+    // arrive on a Stream), stopping early if the context is cancelled.
+    // Every send that has to wait for a worker is timed.
+    var blocked time.Duration
+    if c.BatchSize > 0 {
+        produceBatches(feedCtx, batches, next, c.BatchSize, c.Rate, c.Logger, c.Metrics, &blocked)
+    } else {
+        sent := 0
+        produce(feedCtx, next, c.Rate, c.Logger, func(task Task) bool {
+            target := queue.tasks
+            if queues != nil {
+                target = queues[sent%len(queues)].tasks
+                sent++
+            }
+            if !timedSend(feedCtx, target, task, &blocked, c.Metrics) {
+                return false
+            }
+            c.Metrics.submit(1)
+            return true
+        })
+    }
+    if c.Logger != nil {
+        c.Logger.Info("producer finished", "blocked", blocked)
+    }
+    idle := errors.Is(context.Cause(feedCtx), errWorkersIdle) && ctx.Err() == nil
+    if idle && c.Logger != nil {
+        c.Logger.Info("every worker went idle, stopping the pool", "idle_timeout", c.IdleTimeout)
+    }
+
+    close(stopScaling)
+    <-scalingDone
+
+    // Closing the queue and channel tells the workers there is no more
+    // work; each one exits once its queue or channel is drained.
+    queue.Close()
+    for _, q := range queues {
+        q.Close()
+    }
+    close(batches)
+
+    // Wait for all workers to finish, then for the collectors
+    wg.Wait()
+    close(failuresCh)
+    <-failuresDone
+    close(resultsCh)
+    <-resultsDone
+    if n := queue.Len() + len(batches); idle && n > 0 && c.Logger != nil {
+        c.Logger.Warn("tasks arrived after the workers went idle and were not processed", "count", n)
+    }
+    return blocked, idle
+}
+
+// failureLimit returns an error wrapping ErrTooManyFailures if failed
+// failures exceed MaxFailures or MaxFailureRate. The rate needs
+// c.Metrics, which RunReport provides whenever it is set.
+func (c Config) failureLimit(failed int) error {
+    if c.MaxFailures > 0 && failed > c.MaxFailures {
+        return fmt.Errorf("%w: %d tasks failed, more than the limit of %d", ErrTooManyFailures, failed, c.MaxFailures)
+    }
+    if c.MaxFailureRate > 0 {
+        finished := int(c.Metrics.Completed()) + failed
+        if finished >= minFailureRateTasks && float64(failed) > c.MaxFailureRate*float64(finished) {
+            return fmt.Errorf("%w: %d of %d finished tasks failed, more than the limit of %g%%", ErrTooManyFailures, failed, finished, c.MaxFailureRate*100)
+        }
+    }
+    return nil
+}
+
+// validate reports the first invalid setting in c, if any.
+func (c Config) validate() error {
+    if c.Workers <= 0 {
+        return fmt.Errorf("processor: Workers must be positive, got %d", c.Workers)
+    }
+    if c.BufferSize < 0 {
+        return fmt.Errorf("processor: BufferSize must not be negative, got %d", c.BufferSize)
+    }
+    if c.MaxFailures < 0 {
+        return fmt.Errorf("processor: MaxFailures must not be negative, got %d", c.MaxFailures)
+    }
+    if c.MaxFailureRate < 0 || c.MaxFailureRate > 1 {
+        return fmt.Errorf("processor: MaxFailureRate must be between 0 and 1, got %v", c.MaxFailureRate)
+    }
+    if c.Budget < 0 {
+        return fmt.Errorf("processor: Budget must not be negative, got %v", c.Budget)
+    }
+    if c.IdleTimeout < 0 {
+        return fmt.Errorf("processor: IdleTimeout must not be negative, got %v", c.IdleTimeout)
+    }
+    if c.BatchSize < 0 {
+        return fmt.Errorf("processor: BatchSize must not be negative, got %d", c.BatchSize)
+    }
+    if err := c.Backoff.validate(); err != nil {
+        return err
+    }
+    if c.ProgressInterval < 0 {
+        return fmt.Errorf("processor: ProgressInterval must not be negative, got %v", c.ProgressInterval)
+    }
+    if c.MaxInFlight < 0 {
+        return fmt.Errorf("processor: MaxInFlight must not be negative, got %d", c.MaxInFlight)
+    }
+    switch c.Dispatch {
+    case "", "shared":
+    case "roundrobin":
+        if c.MaxWorkers > c.Workers || c.BatchSize > 0 || c.IdleTimeout > 0 {
+            return errors.New("processor: round-robin dispatch cannot be combined with autoscaling, batching or IdleTimeout")
+        }
+    default:
+        return fmt.Errorf("processor: unknown Dispatch mode %q", c.Dispatch)
+    }
+    if c.MaxWorkers > c.Workers {
+        if c.BufferSize == 0 {
+            return errors.New("processor: autoscaling (MaxWorkers > Workers) needs a positive BufferSize")
+        }
+        if c.BatchSize > 0 {
+            return errors.New("processor: autoscaling is not supported in batch mode")
+        }
+    }
+    if c.Results != nil && c.Ordered {
+        return errors.New("processor: Ordered cannot be combined with a Results channel")
+    }
+    if c.Reducer != nil && (c.Results != nil || c.Ordered || c.SplitOutput != nil) {
+        return errors.New("processor: Reducer cannot be combined with Results, Ordered or SplitOutput")
+    }
+    if c.SampleRate < 0 {
+        return fmt.Errorf("processor: SampleRate must not be negative, got %d", c.SampleRate)
+    }
+    if c.SampleRate > 1 && c.SplitOutput != nil {
+        return errors.New("processor: SampleRate cannot be combined with SplitOutput")
+    }
+    if c.Snapshot != nil && (c.Results != nil || c.Reducer != nil || c.SplitOutput != nil) {
+        return errors.New("processor: Snapshot cannot be combined with Results, Reducer or SplitOutput")
+    }
+    if c.SplitOutput != nil && (c.Results != nil || c.Ordered || c.Checkpoint != nil) {
+        return errors.New("processor: SplitOutput cannot be combined with Results, Ordered or Checkpoint")
+    }
+    if c.MaxDataLen < 0 {
+        return fmt.Errorf("processor: MaxDataLen must not be negative, got %d", c.MaxDataLen)
+    }
+    if c.OnOversize != "" && c.OnOversize != "truncate" && c.OnOversize != "reject" {
+        return fmt.Errorf("processor: unknown OnOversize policy %q", c.OnOversize)
+    }
+    if c.TaskTimeout < 0 {
+        return fmt.Errorf("processor: TaskTimeout must not be negative, got %v", c.TaskTimeout)
+    }
+    if c.Rate < 0 {
+        return fmt.Errorf("processor: Rate must not be negative, got %v", c.Rate)
+    }
+    if c.MaxRetries < 0 {
+        return fmt.Errorf("processor: MaxRetries must not be negative, got %d", c.MaxRetries)
+    }
+    return nil
+}
+
+// limitFeed wraps next to enforce MaxDataLen, truncating oversized
+// tasks or passing them to fail and moving on to the next task.
+func (c Config) limitFeed(next func() (Task, bool), fail func(Failure), log *slog.Logger) func() (Task, bool) {
+    return func() (Task, bool) {
+        for {
+            task, ok := next()
+            if !ok {
+                return task, ok
+            }
+            length := utf8.RuneCountInString(task.Data)
+            if length <= c.MaxDataLen {
+                return task, true
+            }
+            if c.OnOversize != "reject" {
+                log.Warn("truncating oversized task data", "task_id", task.ID, "length", length, "max_data_len", c.MaxDataLen)
+                task.Data = truncateRunes(task.Data, c.MaxDataLen)
+                return task, true
+            }
+            log.Warn("rejecting oversized task", "task_id", task.ID, "length", length, "max_data_len", c.MaxDataLen)
+            err := &ProcessError{Kind: ErrInvalidInput, Err: fmt.Errorf("data has %d characters, over the limit of %d", length, c.MaxDataLen)}
+            fail(Failure{Task: task, Err: err})
+            c.Progress.add()
+        }
+    }
+}
+
+// truncateRunes returns the first n characters of s.
+func truncateRunes(s string, n int) string {
+    for i := range s {
+        if n == 0 {
+            return s[:i]
+        }
+        n--
+    }
+    return s
+}
+
+// taskFeed returns the producer's source of tasks: the Stream if one
+// is set, otherwise a priority heap over Tasks. It reports false once
+// there are no more tasks or ctx is cancelled.
+func (c Config) taskFeed(ctx context.Context) func() (Task, bool) {
+    if c.Stream == nil {
+        pending := newTaskHeap(c.Tasks)
+        return func() (Task, bool) {
+            if pending.Len() == 0 {
+                return Task{}, false
+            }
+            return pending.popTask(), true
+        }
+    }
+    return func() (Task, bool) {
+        select {
+        case task, ok := <-c.Stream:
+            return task, ok
+        case <-ctx.Done():
+            return Task{}, false
+        }
+    }
+}
+
+// skipFeed wraps next so that tasks for which skip reports true are
+// never dispatched, counting them in *skipped and taking them out of
+// the progress total.
+func skipFeed(next func() (Task, bool), skip func(Task) bool, skipped *int, progress *Progress) func() (Task, bool) {
+    return func() (Task, bool) {
+        for {
+            task, ok := next()
+            if !ok || !skip(task) {
+                return task, ok
+            }
+            *skipped++
+            progress.skip()
+        }
+    }
+}
+
+// produce hands every task from next to send: highest priority first
+// for a task list, arrival order for a stream, stamping each that has
+// no Seq yet with the next one; a task relayed from an earlier stage
+// of RunStages keeps its own. If rate is positive, a
+// ticker spaces the tasks so that at most rate tasks start per second;
+// the first task goes out immediately. It gives up as soon as ctx is
+// cancelled or send reports false, since the workers may no longer be
+// receiving.
+func produce(ctx context.Context, next func() (Task, bool), rate float64, log *slog.Logger, send func(Task) bool) {
+    if log == nil {
+        log = discardLogger
+    }
+
+    var tick <-chan time.Time
+    if rate > 0 {
+        ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+        defer ticker.Stop()
+        tick = ticker.C
+    }
+
+    seq := 0
+    for first := true; ; first = false {
+        // A feed over Tasks never blocks, so it does not watch ctx.
+        if ctx.Err() != nil {
+            log.Warn("producer stopped adding tasks: context cancelled", "error", context.Cause(ctx))
+            return
+        }
+        task, ok := next()
+        if !ok {
+            if ctx.Err() != nil {
+                log.Warn("producer stopped adding tasks: context cancelled", "error", context.Cause(ctx))
+            }
+            return
+        }
+
+        if tick != nil && !first {
+            select {
+            case <-tick:
+            case <-ctx.Done():
+                log.Warn("producer stopped adding tasks: context cancelled", "error", context.Cause(ctx))
+                return
+            }
+        }
+
+        seq++
+        if task.Seq == 0 {
+            task.Seq = seq
+        }
+        log.Debug("adding task to the channel", "task_id", task.ID, "seq", task.Seq, "priority", task.Priority, "data", task.Data)
+        if !send(task) {
+            log.Warn("producer stopped adding tasks: context cancelled", "error", context.Cause(ctx))
+            return
+        }
+    }
+}
+
+// produceBatches runs produce, grouping the tasks into batches of up
+// to batchSize before sending them on the batches channel. A final
+// partial batch is sent once the tasks run out. Time spent waiting for
+// a worker is added to *blocked.
+func produceBatches(ctx context.Context, batches chan<- []Task, next func() (Task, bool), batchSize int, rate float64, log *slog.Logger, metrics *Metrics, blocked *time.Duration) {
+    sendBatch := func(batch []Task) bool {
+        if !timedSend(ctx, batches, batch, blocked, metrics) {
+            return false
+        }
+        metrics.submit(len(batch))
+        return true
+    }
+
+    batch := make([]Task, 0, batchSize)
+    produce(ctx, next, rate, log, func(task Task) bool {
+        batch = append(batch, task)
+        if len(batch) < batchSize {
+            return true
+        }
+        full := batch
+        batch = make([]Task, 0, batchSize)
+        return sendBatch(full)
+    })
+    if len(batch) > 0 && ctx.Err() == nil {
+        sendBatch(batch)
+    }
+}
+
+// timedSend sends v on ch, giving up if ctx is cancelled first, and
+// reports whether it was sent. If no receiver is ready straight away,
+// the time spent waiting is added to *blocked and to metrics.
+func timedSend[T any](ctx context.Context, ch chan<- T, v T, blocked *time.Duration, metrics *Metrics) bool {
+    select {
+    case ch <- v:
+        return true
+    default:
+    }
+
+    start := time.Now()
+    defer func() {
+        waited := time.Since(start)
+        *blocked += waited
+        metrics.producerBlocked(waited)
+    }()
+    select {
+    case ch <- v:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
+
+// SortResultsByTaskID sorts results in place by ascending TaskID.
+func SortResultsByTaskID(results []Result) {
+    sort.Slice(results, func(i, j int) bool {
+        return taskOrder(results[i], results[j])
+    })
+}
+
+// SortResultsByLength sorts results in place by ascending output
+// Length, breaking ties by TaskID.
+func SortResultsByLength(results []Result) {
+    sort.Slice(results, func(i, j int) bool {
+        if results[i].Length != results[j].Length {
+            return results[i].Length < results[j].Length
+        }
+        return taskOrder(results[i], results[j])
+    })
+}
+
+// SortResultsByInput sorts results in place by Input, byte-wise
+// ascending, breaking ties by TaskID.
+func SortResultsByInput(results []Result) {
+    sort.Slice(results, func(i, j int) bool {
+        if results[i].Input != results[j].Input {
+            return results[i].Input < results[j].Input
+        }
+        return taskOrder(results[i], results[j])
+    })
+}
+
+// taskOrder reports whether a comes before b in task ID order, the
+// parts of a split task by SubIndex.
+func taskOrder(a, b Result) bool {
+    if a.TaskID != b.TaskID {
+        return a.TaskID < b.TaskID
+    }
+    return a.SubIndex < b.SubIndex
+}
+
+// IsCancelled reports whether err is the context error returned by
+// Run or RunReport when a run is stopped early.
+func IsCancelled(err error) bool {
+    return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,198 @@
+package processor
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// identity is a no-op transform, so that the benchmarks measure the
+// pool's scheduling overhead rather than the work done per task.
+func identity(s string) string { return s }
+
+// benchTasks returns n tiny tasks.
+func benchTasks(n int) []Task {
+    tasks := make([]Task, n)
+    for i := range tasks {
+        tasks[i] = Task{ID: i + 1, Data: "task_data_" + strconv.Itoa(i+1)}
+    }
+    return tasks
+}
+
+// benchConfig is a run of workers over the identity transform, without
+// the simulated delay.
+func benchConfig(workers int) Config {
+    return Config{Workers: workers, Transform: identity, TransformName: "identity", NoDelay: true}
+}
+
+// benchmarkRun runs tasks through config b.N times and reports the
+// throughput in tasks/sec.
+func benchmarkRun(b *testing.B, config Config, tasks []Task) {
+    b.Helper()
+    config.Tasks = tasks
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        results, err := Run(config)
+        if err != nil {
+            b.Fatal(err)
+        }
+        if len(results) != len(tasks) {
+            b.Fatalf("got %d results, want %d", len(results), len(tasks))
+        }
+    }
+    b.ReportMetric(float64(len(tasks)*b.N)/b.Elapsed().Seconds(), "tasks/sec")
+}
+
+func BenchmarkRun(b *testing.B) {
+    for _, workers := range []int{1, 4, 16} {
+        for _, n := range []int{100, 1000, 10000} {
+            b.Run(fmt.Sprintf("workers=%d/tasks=%d", workers, n), func(b *testing.B) {
+                benchmarkRun(b, benchConfig(workers), benchTasks(n))
+            })
+        }
+    }
+}
+
+func BenchmarkBufferSize(b *testing.B) {
+    for _, buffer := range []int{0, 16, 256} {
+        b.Run(fmt.Sprintf("buffer=%d", buffer), func(b *testing.B) {
+            config := benchConfig(4)
+            config.BufferSize = buffer
+            benchmarkRun(b, config, benchTasks(1000))
+        })
+    }
+}
+
+func BenchmarkBatchSize(b *testing.B) {
+    tasks := make([]Task, 10000)
+    for i := range tasks {
+        tasks[i] = Task{ID: i + 1, Data: "x"}
+    }
+    for _, batch := range []int{1, 32} {
+        b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+            config := benchConfig(4)
+            config.BatchSize = batch
+            benchmarkRun(b, config, tasks)
+        })
+    }
+}
+
+// BenchmarkCollect compares the two ways of gathering results from
+// many workers: sending them on one channel drained by a single
+// collector, as the pool does, and appending them to a shared slice
+// under a mutex, as it used to. Each of the workers finishes 100 tasks.
+func BenchmarkCollect(b *testing.B) {
+    const perWorker = 100
+    collectors := map[string]func(workers int) int{
+        "channel": func(workers int) int {
+            results := make(chan Result)
+            var wg sync.WaitGroup
+            for w := 1; w <= workers; w++ {
+                wg.Add(1)
+                go func(id int) {
+                    defer wg.Done()
+                    for i := 0; i < perWorker; i++ {
+                        results <- Result{WorkerID: id, TaskID: i}
+                    }
+                }(w)
+            }
+            go func() {
+                wg.Wait()
+                close(results)
+            }()
+            var collected []Result
+            for r := range results {
+                collected = append(collected, r)
+            }
+            return len(collected)
+        },
+        "mutex": func(workers int) int {
+            var collected []Result
+            var mu sync.Mutex
+            var wg sync.WaitGroup
+            for w := 1; w <= workers; w++ {
+                wg.Add(1)
+                go func(id int) {
+                    defer wg.Done()
+                    for i := 0; i < perWorker; i++ {
+                        mu.Lock()
+                        collected = append(collected, Result{WorkerID: id, TaskID: i})
+                        mu.Unlock()
+                    }
+                }(w)
+            }
+            wg.Wait()
+            return len(collected)
+        },
+    }
+    for _, name := range []string{"channel", "mutex"} {
+        for _, workers := range []int{16, 64, 256} {
+            b.Run(fmt.Sprintf("%s/workers=%d", name, workers), func(b *testing.B) {
+                b.ReportAllocs()
+                for i := 0; i < b.N; i++ {
+                    if n := collectors[name](workers); n != workers*perWorker {
+                        b.Fatalf("collected %d results, want %d", n, workers*perWorker)
+                    }
+                }
+                b.ReportMetric(float64(workers*perWorker*b.N)/b.Elapsed().Seconds(), "tasks/sec")
+            })
+        }
+    }
+}
+
+// BenchmarkManyWorkers runs the whole pool with high worker counts,
+// where every worker competes to hand its results to the collector.
+func BenchmarkManyWorkers(b *testing.B) {
+    for _, workers := range []int{64, 256} {
+        b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+            benchmarkRun(b, benchConfig(workers), benchTasks(10000))
+        })
+    }
+}
+
+// BenchmarkDispatch contrasts shared and round-robin dispatch over 8
+// workers with uneven tasks: every 8th is 1,000 times longer than the
+// rest, under a transform whose cost grows with the length. Besides
+// tasks/sec it reports load balance as max-work/mean, the busiest
+// worker's share of the output characters over the average share;
+// 1 is a perfect balance.
+func BenchmarkDispatch(b *testing.B) {
+    const workers = 8
+    tasks := make([]Task, 2000)
+    for i := range tasks {
+        data := "x"
+        if i%workers == 0 {
+            data = strings.Repeat("x", 1000)
+        }
+        tasks[i] = Task{ID: i + 1, Data: data}
+    }
+    for _, dispatch := range []string{"shared", "roundrobin"} {
+        b.Run(dispatch, func(b *testing.B) {
+            config := Config{Tasks: tasks, Workers: workers, BufferSize: 4, Dispatch: dispatch, NoDelay: true}
+            imbalance := 0.0
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                results, err := Run(config)
+                if err != nil {
+                    b.Fatal(err)
+                }
+                work := make(map[int]int)
+                total := 0
+                for _, r := range results {
+                    work[r.WorkerID] += r.Length
+                    total += r.Length
+                }
+                busiest := 0
+                for _, n := range work {
+                    busiest = max(busiest, n)
+                }
+                imbalance += float64(busiest) / (float64(total) / workers)
+            }
+            b.ReportMetric(float64(len(tasks)*b.N)/b.Elapsed().Seconds(), "tasks/sec")
+            b.ReportMetric(imbalance/float64(b.N), "max-work/mean")
+        })
+    }
+}
@@ -0,0 +1,134 @@
+package processor
+
+import (
+    "fmt"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestEveryTaskProcessedOnce(t *testing.T) {
+    tasks := GenerateTasks(10)
+    for _, workers := range []int{1, 3, 16} {
+        t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+            report, err := RunReport(Config{Tasks: tasks, Workers: workers})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(report.Failures) > 0 {
+                t.Fatalf("%d tasks failed", len(report.Failures))
+            }
+            seen := make(map[int]int)
+            for _, r := range report.Results {
+                seen[r.TaskID]++
+            }
+            for _, task := range tasks {
+                if n := seen[task.ID]; n != 1 {
+                    t.Errorf("Task-%d processed %d times, want 1", task.ID, n)
+                }
+            }
+            if len(report.Results) != len(tasks) {
+                t.Errorf("got %d results, want %d", len(report.Results), len(tasks))
+            }
+        })
+    }
+}
+
+func TestRateLimitsDispatch(t *testing.T) {
+    if testing.Short() {
+        t.Skip("takes about 2s")
+    }
+    start := time.Now()
+    results, err := Run(Config{Tasks: GenerateTasks(20), Workers: 8, Rate: 10})
+    elapsed := time.Since(start)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(results) != 20 {
+        t.Fatalf("got %d results, want 20", len(results))
+    }
+    // The first task goes at once and the other 19 a tenth of a second
+    // apart, so the last one starts after 1.9s and finishes within its
+    // simulated delay of at most 500ms. Eight workers keep up with the
+    // rate whatever the delays.
+    if elapsed < 1900*time.Millisecond || elapsed > 2700*time.Millisecond {
+        t.Errorf("20 tasks at rate 10 took %v, want about 2s", elapsed)
+    }
+}
+
+func TestCheckpointLeavesStreamedResultsToReceiver(t *testing.T) {
+    checkpoint, err := OpenCheckpoint(filepath.Join(t.TempDir(), "checkpoint"), false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    results := make(chan Result)
+    done := make(chan int)
+    go func() {
+        n := 0
+        for r := range results {
+            if checkpoint.Done(r.TaskID) {
+                t.Errorf("Task-%d marked done before the receiver stored it", r.TaskID)
+            }
+            n++
+        }
+        done <- n
+    }()
+    _, err = RunReport(Config{Tasks: GenerateTasks(5), Workers: 2, Checkpoint: checkpoint, Results: results})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := <-done; n != 5 {
+        t.Fatalf("received %d results, want 5", n)
+    }
+    if n := checkpoint.Len(); n != 0 {
+        t.Errorf("run marked %d streamed tasks itself, want 0", n)
+    }
+}
+
+func TestReportCountsTasks(t *testing.T) {
+    tasks := []Task{{ID: 1, Data: "a"}, {ID: 2, Data: "b"}, {ID: 3, Data: "boom"}}
+    transform := func(s string) string {
+        if s == "boom" {
+            panic("boom")
+        }
+        return strings.ToUpper(s)
+    }
+    report, err := RunReport(Config{Tasks: tasks, Workers: 2, NoDelay: true, Transform: transform})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if report.Summary.Tasks != 2 || len(report.Failures) != 1 {
+        t.Fatalf("got %d results and %d failures, want 2 and 1", report.Summary.Tasks, len(report.Failures))
+    }
+    if report.Tasks != 3 {
+        t.Errorf("Report.Tasks = %d, want 3", report.Tasks)
+    }
+}
+
+func TestReportCountsFannedOutTasksOnce(t *testing.T) {
+    tasks := []Task{{ID: 1, Data: "a b c"}, {ID: 2, Data: "d e"}, {ID: 3, Data: "boom"}}
+    fanOut := func(s string) []string {
+        if s == "BOOM" {
+            panic("boom")
+        }
+        return strings.Fields(s)
+    }
+    report, err := RunReport(Config{Tasks: tasks, Workers: 2, NoDelay: true, FanOut: fanOut})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if report.Summary.Tasks != 5 || len(report.Failures) != 1 {
+        t.Fatalf("got %d results and %d failures, want 5 and 1", report.Summary.Tasks, len(report.Failures))
+    }
+    if report.Tasks != 3 {
+        t.Errorf("Report.Tasks = %d, want 3", report.Tasks)
+    }
+    processed := 0
+    for _, s := range report.Stats {
+        processed += s.TasksProcessed
+    }
+    if processed != 2 {
+        t.Errorf("workers processed %d tasks, want 2", processed)
+    }
+}
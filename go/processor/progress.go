@@ -0,0 +1,106 @@
+package processor
+
+import (
+    "fmt"
+    "io"
+    "sync/atomic"
+    "time"
+)
+
+// Progress counts finished tasks (processed or failed) out of a total,
+// which is 0 when it is not known up front (a streamed run). Workers
+// update it atomically, so it can be read while a run is in progress.
+type Progress struct {
+    total atomic.Int64
+    done  atomic.Int64
+}
+
+// NewProgress returns a Progress for a run of total tasks; pass 0 if
+// the number of tasks is not known in advance.
+func NewProgress(total int) *Progress {
+    p := &Progress{}
+    p.total.Store(int64(total))
+    return p
+}
+
+// Done returns how many tasks have finished so far.
+func (p *Progress) Done() int {
+    return int(p.done.Load())
+}
+
+// Total returns the number of tasks in the run.
+func (p *Progress) Total() int {
+    return int(p.total.Load())
+}
+
+// String formats the progress as "processed 43/100 (43%)", or just
+// "processed 43" when the total is unknown.
+func (p *Progress) String() string {
+    done, total := p.Done(), p.Total()
+    if total == 0 {
+        return fmt.Sprintf("processed %d", done)
+    }
+    percent := done * 100 / total
+    return fmt.Sprintf("processed %d/%d (%d%%)", done, total, percent)
+}
+
+// add records one more finished task; it is a no-op on a nil Progress.
+func (p *Progress) add() {
+    if p != nil {
+        p.done.Add(1)
+    }
+}
+
+// skip takes a task that will never run (e.g. filtered out) off the
+// total; it is a no-op on a nil Progress or an unknown total.
+func (p *Progress) skip() {
+    if p != nil && p.Total() > 0 {
+        p.total.Add(-1)
+    }
+}
+
+// defaultProgressInterval is how often Config.ProgressFunc is called
+// when no ProgressInterval is set.
+const defaultProgressInterval = 100 * time.Millisecond
+
+// notify calls fn with p's counts every interval while they change,
+// until stop is closed, then once more and closes finished.
+func (p *Progress) notify(fn func(done, total int), interval time.Duration, stop <-chan struct{}, finished chan<- struct{}) {
+    defer close(finished)
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    last := -1
+    for {
+        select {
+        case <-ticker.C:
+            if done := p.Done(); done != last {
+                last = done
+                fn(done, p.Total())
+            }
+        case <-stop:
+            fn(p.Done(), p.Total())
+            return
+        }
+    }
+}
+
+// Report writes p's progress line to w every interval until stop is
+// closed, then writes a final line and closes finished.
+func (p *Progress) Report(w io.Writer, interval time.Duration, stop <-chan struct{}, finished chan<- struct{}) {
+    defer close(finished)
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            fmt.Fprintln(w, p)
+        case <-stop:
+            fmt.Fprintln(w, p)
+            return
+        }
+    }
+}
@@ -0,0 +1,91 @@
+package processor
+
+import (
+    "context"
+    "time"
+)
+
+// TaskQueue is where workers take their tasks from. Next blocks until
+// a task is available and returns it, or returns false once the queue
+// has been closed and drained (or can no longer deliver, e.g. because
+// its context was cancelled). Close tells the queue that no more tasks
+// are coming; consumers still receive the ones already queued. Next
+// may be called from several workers at once.
+type TaskQueue interface {
+    Next() (Task, bool)
+    Close()
+}
+
+// TimeoutQueue is a TaskQueue that can also give up waiting:
+// NextTimeout is Next, except that it returns timedOut (and no task)
+// once timeout passes with nothing to hand out. Workers with an
+// IdleTimeout need their queue to implement it.
+type TimeoutQueue interface {
+    TaskQueue
+    NextTimeout(timeout time.Duration) (task Task, ok, timedOut bool)
+}
+
+// ChannelQueue is the in-memory TaskQueue the pool uses: a Go channel
+// with an optional buffer. Put blocks while the buffer is full, so a
+// producer can never run ahead of the workers by more than the buffer.
+// Once ctx is cancelled, Put and Next stop waiting and report false.
+type ChannelQueue struct {
+    ctx   context.Context
+    tasks chan Task
+}
+
+// NewChannelQueue returns an empty ChannelQueue with room for buffer
+// tasks (0 means unbuffered) that stops waiting once ctx is done.
+func NewChannelQueue(ctx context.Context, buffer int) *ChannelQueue {
+    return &ChannelQueue{ctx: ctx, tasks: make(chan Task, buffer)}
+}
+
+// Put adds task to the queue, waiting for room, and reports false if
+// ctx was cancelled first. It must not be called after Close.
+func (q *ChannelQueue) Put(task Task) bool {
+    select {
+    case q.tasks <- task:
+        return true
+    case <-q.ctx.Done():
+        return false
+    }
+}
+
+// Next implements TaskQueue.
+func (q *ChannelQueue) Next() (Task, bool) {
+    select {
+    case task, ok := <-q.tasks:
+        return task, ok
+    case <-q.ctx.Done():
+        return Task{}, false
+    }
+}
+
+// NextTimeout implements TimeoutQueue.
+func (q *ChannelQueue) NextTimeout(timeout time.Duration) (task Task, ok, timedOut bool) {
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    select {
+    case task, ok := <-q.tasks:
+        return task, ok, false
+    case <-q.ctx.Done():
+        return Task{}, false, false
+    case <-timer.C:
+        return Task{}, false, true
+    }
+}
+
+// Close implements TaskQueue.
+func (q *ChannelQueue) Close() {
+    close(q.tasks)
+}
+
+// Len returns the number of tasks waiting in the buffer.
+func (q *ChannelQueue) Len() int {
+    return len(q.tasks)
+}
+
+// Cap returns the capacity of the buffer.
+func (q *ChannelQueue) Cap() int {
+    return cap(q.tasks)
+}
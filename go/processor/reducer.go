@@ -0,0 +1,11 @@
+package processor
+
+// Reducer aggregates the results of a run as they complete, instead of
+// the run collecting them all; see Config.Reducer. Add is called once
+// per Result, always from the same goroutine, so it needs no locking;
+// Finish is called once the workers are done and its value is returned
+// as Report.Reduced.
+type Reducer interface {
+    Add(Result)
+    Finish() any
+}
@@ -0,0 +1,43 @@
+package processor
+
+import (
+    "sync"
+    "time"
+)
+
+// snapshotTimeFormat stamps snapshot file names; it sorts in time
+// order and has no characters that need quoting in a shell.
+const snapshotTimeFormat = "20060102T150405.000Z"
+
+// SnapshotPath names a snapshot of the results taken at based on
+// path, the final output file, by inserting "_snapshot_<UTC time>"
+// before the extension: "results.txt" becomes
+// "results_snapshot_20240102T150405.000Z.txt".
+func SnapshotPath(path string, at time.Time) string {
+    return insertBeforeExt(path, "_snapshot_"+at.UTC().Format(snapshotTimeFormat))
+}
+
+// serveSnapshots answers each request received from requests with a
+// copy of *results, taken under mu so that the collector can keep
+// appending, until stop is closed. It closes done when it returns.
+func serveSnapshots(requests <-chan chan<- []Result, mu *sync.Mutex, results *[]Result, stop <-chan struct{}, done chan<- struct{}) {
+    defer close(done)
+    for {
+        select {
+        case reply, ok := <-requests:
+            if !ok {
+                return
+            }
+            mu.Lock()
+            snapshot := append([]Result(nil), *results...)
+            mu.Unlock()
+            select {
+            case reply <- snapshot:
+            case <-stop:
+                return
+            }
+        case <-stop:
+            return
+        }
+    }
+}
@@ -0,0 +1,106 @@
+package processor
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "path/filepath"
+    "strings"
+)
+
+// OutputFile describes one of the per-worker files written with
+// Config.SplitOutput: the number of results written to it and the
+// first error met creating or writing it, if any.
+type OutputFile struct {
+    WorkerID int
+    Path     string
+    Results  int
+    Err      error
+}
+
+// SplitOutputPath names worker workerID's file for a split output
+// based on path, by inserting "_worker_<id>" before the extension:
+// "results.txt" becomes "results_worker_1.txt", and "results.csv.gz"
+// becomes "results_worker_1.csv.gz".
+func SplitOutputPath(path string, workerID int) string {
+    return insertBeforeExt(path, fmt.Sprintf("_worker_%d", workerID))
+}
+
+// insertBeforeExt inserts suffix into the file name of path before its
+// extension, keeping a ".gz" after the extension.
+func insertBeforeExt(path, suffix string) string {
+    dir, name := filepath.Split(path)
+    base, gz := strings.CutSuffix(name, ".gz")
+    ext := filepath.Ext(base)
+    name = strings.TrimSuffix(base, ext) + suffix + ext
+    if gz {
+        name += ".gz"
+    }
+    return dir + name
+}
+
+// splitOutput is a worker's own results file. Only its worker touches
+// it while the pool runs, so it needs no locking. The file is created
+// (or, with append, opened for appending) with the worker's first
+// result; after a write error the remaining
+// results are dropped and the error is kept for close.
+type splitOutput struct {
+    path     string
+    encoding encoding
+    append   bool
+
+    file     io.WriteCloser
+    buffered *bufio.Writer
+    encode   func(Result) error
+    finish   func() error
+
+    count   int
+    summary Summary
+    err     error
+}
+
+// write encodes one result into the file, creating it first if needed.
+func (s *splitOutput) write(r Result) {
+    s.summary.Add(r)
+    if s.err != nil {
+        return
+    }
+    if s.file == nil {
+        continuing := false
+        if s.append {
+            s.file, continuing, s.err = openAppend(s.path, s.encoding.format)
+        } else {
+            s.file, s.err = createOutput(s.path)
+        }
+        if s.err != nil {
+            return
+        }
+        s.buffered = bufio.NewWriter(s.file)
+        if s.encode, s.finish, s.err = newStreamEncoder(s.buffered, s.encoding, continuing); s.err != nil {
+            return
+        }
+    }
+    if s.err = s.encode(r); s.err == nil {
+        s.count++
+    }
+}
+
+// close finishes and closes the file, if one was created, returning
+// the first error met while creating or writing it.
+func (s *splitOutput) close() error {
+    if s.file != nil {
+        if s.err == nil {
+            s.err = s.finish()
+        }
+        if s.err == nil {
+            s.err = s.buffered.Flush()
+        }
+        if err := s.file.Close(); s.err == nil {
+            s.err = err
+        }
+    }
+    if s.err != nil {
+        return fmt.Errorf("writing %s: %w", s.path, s.err)
+    }
+    return nil
+}
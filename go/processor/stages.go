@@ -0,0 +1,161 @@
+package processor
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+)
+
+// Stage is one worker pool of a multi-stage run; see RunStages. Each
+// stage has its own transform (nil means the identity), optional
+// FanOut and worker count.
+type Stage struct {
+    Workers       int
+    Transform     Transform
+    TransformName string
+    FanOut        FanOut
+}
+
+// RunStages runs config's tasks through several worker pools in a row,
+// like an ETL pipeline: the results of stage N become the tasks of
+// stage N+1, keeping their IDs, Seq, sources and tags, with each
+// output as the next stage's data (a FanOut stage passes on each of
+// its results as a task of its own). The stages run concurrently,
+// connected by channels, so a task can be in the second stage while
+// later ones are still in the first. SampleRate in the last stage
+// therefore samples by the first stage's dispatch order.
+//
+// The stage settings replace config's Workers, Transform, TransformName
+// and FanOut; everything else applies to every stage, except that the
+// input settings (Tasks, Stream, Filter, MaxDataLen, Decode, Rate,
+// Budget, IdleTimeout, Cancel, ExactlyOnce, Pause) belong to the first
+// stage and the output ones (Results, Reducer, SplitOutput, Ordered,
+// Progress, ProgressFunc, Metrics, Snapshot, SampleRate) to the last.
+// Checkpoint and Drain are not supported. FailFast and the failure
+// limits count each stage's failures separately. Log records carry the
+// 1-based stage number.
+//
+// It returns one Report per stage, in order: the last holds the final
+// results, and each holds its own stage's failures, worker statistics
+// and summary. When a stage stops with an error the other stages are
+// cancelled too, and the error returned is the first one to happen.
+func RunStages(config Config, stages ...Stage) ([]*Report, error) {
+    configs, err := config.stageConfigs(stages)
+    if err != nil {
+        if config.Results != nil {
+            close(config.Results)
+        }
+        return nil, err
+    }
+
+    ctx := config.Context
+    if ctx == nil {
+        ctx = context.Background()
+    }
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    // Between two stages, a goroutine turns the results of the first
+    // into tasks for the second. Once the second has returned it only
+    // drains the channel, so the first never blocks on it.
+    links := make([]chan Result, len(configs)-1)
+    for i := range links {
+        links[i] = make(chan Result)
+        configs[i].Results = links[i]
+    }
+
+    reports := make([]*Report, len(configs))
+    var mu sync.Mutex
+    var firstErr error
+    var wg sync.WaitGroup
+    for i := range configs {
+        configs[i].Context = ctx
+        done := make(chan struct{})
+        if i > 0 {
+            tasks := make(chan Task)
+            configs[i].Stream = tasks
+            go relay(links[i-1], tasks, done)
+        }
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            defer close(done)
+            report, err := RunReport(configs[i])
+            reports[i] = report
+            if err != nil {
+                mu.Lock()
+                if firstErr == nil {
+                    firstErr = fmt.Errorf("stage %d: %w", i+1, err)
+                }
+                mu.Unlock()
+                cancel()
+            }
+        }(i)
+    }
+    wg.Wait()
+    return reports, firstErr
+}
+
+// relay forwards each Result from results to tasks as a Task for the
+// next stage until results is closed, then closes tasks. It keeps the
+// Seq, so that the last stage's results carry the first stage's
+// dispatch order. After done is closed it discards what is left.
+func relay(results <-chan Result, tasks chan<- Task, done <-chan struct{}) {
+    defer close(tasks)
+    for r := range results {
+        select {
+        case tasks <- Task{ID: r.TaskID, Data: r.Output, Source: r.Source, Seq: r.Seq, Tags: r.Tags}:
+        case <-done:
+            drain(results)
+            return
+        }
+    }
+}
+
+// stageConfigs builds and validates the Config of every stage.
+func (c Config) stageConfigs(stages []Stage) ([]Config, error) {
+    if len(stages) == 0 {
+        return nil, errors.New("processor: RunStages needs at least one stage")
+    }
+    if c.Checkpoint != nil {
+        return nil, errors.New("processor: Checkpoint cannot be used with RunStages")
+    }
+    if c.Drain {
+        return nil, errors.New("processor: Drain cannot be used with RunStages")
+    }
+    configs := make([]Config, len(stages))
+    last := len(stages) - 1
+    for i, stage := range stages {
+        sc := c
+        sc.Workers = stage.Workers
+        sc.Transform, sc.TransformName, sc.FanOut = stage.Transform, stage.TransformName, stage.FanOut
+        if sc.Logger != nil {
+            sc.Logger = sc.Logger.With("stage", i+1)
+        }
+        if sc.Transform == nil {
+            sc.Transform = func(s string) string { return s }
+            if sc.TransformName == "" {
+                sc.TransformName = "identity"
+            }
+        }
+        if i > 0 {
+            sc.Tasks, sc.Filter, sc.MaxDataLen, sc.Rate = nil, nil, 0, 0
+            sc.Budget, sc.IdleTimeout, sc.Cancel, sc.ExactlyOnce, sc.Pause = 0, 0, nil, false, nil
+            sc.Decode = nil
+            // A placeholder until RunStages connects the stages, so
+            // that validate sees a streaming stage.
+            sc.Stream = make(chan Task)
+        }
+        if i < last {
+            sc.Reducer, sc.SplitOutput, sc.Ordered, sc.Progress, sc.Metrics = nil, nil, false, nil, nil
+            sc.Snapshot, sc.SampleRate, sc.ProgressFunc = nil, 0, nil
+            sc.Results = make(chan Result)
+        }
+        if err := sc.validate(); err != nil {
+            return nil, fmt.Errorf("stage %d: %w", i+1, err)
+        }
+        configs[i] = sc
+    }
+    return configs, nil
+}
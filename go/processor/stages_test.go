@@ -0,0 +1,44 @@
+package processor
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestRunStagesKeepsSeq(t *testing.T) {
+    const n = 60
+    // Tasks ending in an odd digit are slow in the first stage, so the
+    // second receives them out of dispatch order.
+    slowOdd := func(s string) string {
+        if strings.ContainsAny(s[len(s)-1:], "13579") {
+            time.Sleep(2 * time.Millisecond)
+        }
+        return strings.ToUpper(s)
+    }
+    config := Config{Tasks: GenerateTasks(n), NoDelay: true, SampleRate: 3}
+    reports, err := RunStages(config,
+        Stage{Workers: 4, Transform: slowOdd, TransformName: "slow-odd"},
+        Stage{Workers: 1},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    last := reports[len(reports)-1]
+    if want := n / 3; len(last.Results) != want {
+        t.Fatalf("sampled %d results, want %d", len(last.Results), want)
+    }
+    for _, r := range last.Results {
+        // The first stage dispatches the tasks in ID order.
+        if r.Seq != r.TaskID {
+            t.Errorf("Task-%d has Seq %d in the last stage, want its first-stage Seq %d", r.TaskID, r.Seq, r.TaskID)
+        }
+        if (r.Seq-1)%3 != 0 {
+            t.Errorf("Task-%d sampled with Seq %d, want every 3rd from 1", r.TaskID, r.Seq)
+        }
+    }
+    if last.Summary.Tasks != n {
+        t.Errorf("last stage summarized %d tasks, want %d", last.Summary.Tasks, n)
+    }
+}
@@ -0,0 +1,85 @@
+package processor
+
+import (
+    "fmt"
+    "strings"
+    "unicode/utf8"
+)
+
+// Summary aggregates a run's results, reducing the per-task outputs to
+// a handful of totals. Lengths count characters (runes), like
+// Result.Length.
+type Summary struct {
+    Tasks        int
+    InputLength  int
+    OutputLength int
+
+    // LongestTaskID is the task with the longest output, the lowest ID
+    // on a tie; it and LongestLength are 0 when there are no results.
+    LongestTaskID int
+    LongestLength int
+
+    // Filtered counts the tasks excluded by Config.Filter.
+    Filtered int
+
+    // FailuresByKind counts the tasks that failed, keyed by the
+    // KindName of each Failure.
+    FailuresByKind map[string]int
+
+    // latency counts every result's latency for Latency.
+    latency latencyHistogram
+}
+
+// Summarize reduces results to a Summary.
+func Summarize(results []Result) Summary {
+    var s Summary
+    for _, r := range results {
+        s.Add(r)
+    }
+    return s
+}
+
+// Add folds one more result into s, for building a Summary as results
+// arrive.
+func (s *Summary) Add(r Result) {
+    s.Tasks++
+    s.InputLength += utf8.RuneCountInString(r.Input)
+    s.OutputLength += r.Length
+    s.latency.add(resultLatency(r))
+    if s.Tasks == 1 || r.Length > s.LongestLength || (r.Length == s.LongestLength && r.TaskID < s.LongestTaskID) {
+        s.LongestTaskID, s.LongestLength = r.TaskID, r.Length
+    }
+}
+
+// merge folds o, the summary of a separate set of results, into s.
+func (s *Summary) merge(o Summary) {
+    if o.Tasks == 0 {
+        return
+    }
+    if s.Tasks == 0 || o.LongestLength > s.LongestLength || (o.LongestLength == s.LongestLength && o.LongestTaskID < s.LongestTaskID) {
+        s.LongestTaskID, s.LongestLength = o.LongestTaskID, o.LongestLength
+    }
+    s.Tasks += o.Tasks
+    s.InputLength += o.InputLength
+    s.OutputLength += o.OutputLength
+    s.latency.merge(o.latency)
+}
+
+// String formats the summary as a short multi-line block, as printed
+// after a run and appended to text output files, with the failed tasks
+// broken down by kind.
+func (s Summary) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Summary:\n  tasks: %d\n  input length: %d\n  output length: %d\n  longest output: Task-%d (len=%d)\n  filtered out: %d\n",
+        s.Tasks, s.InputLength, s.OutputLength, s.LongestTaskID, s.LongestLength, s.Filtered)
+
+    failed := 0
+    for _, n := range s.FailuresByKind {
+        failed += n
+    }
+    fmt.Fprintf(&b, "  failed: %d\n", failed)
+    for _, kind := range sortedKinds(s.FailuresByKind) {
+        fmt.Fprintf(&b, "    %s: %d\n", kind, s.FailuresByKind[kind])
+    }
+    return b.String()
+}
@@ -0,0 +1,153 @@
+// Package processor implements the worker pool at the heart of the Go
+// Data Processing System: tasks are fed through a shared channel to a
+// fixed number of worker goroutines, each of which simulates some work,
+// transforms the task's data, and records a Result.
+package processor
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Task represents a unit of work in the Go Data Processing System.
+// It has an ID, a piece of text data to process, and a Priority:
+// higher-priority tasks are handed to workers first. Source names the
+// input file the task was read from, if any. Seq is stamped by the
+// producer as it hands the task out: 1 for the first task dispatched,
+// 2 for the next, and so on, whatever the IDs; the later stages of
+// RunStages keep the Seq of the first. Tags is metadata of the
+// caller's own, such as a category, copied unchanged to the results for
+// grouping them.
+type Task struct {
+    ID       int
+    Data     string
+    Priority int
+    Source   string
+    Seq      int
+    Tags     map[string]string
+}
+
+// Result is the outcome of processing a single Task: which worker
+// handled it, the input and transformed output, and how long the
+// simulated work took. Length counts characters (runes), not bytes.
+// Transform names the transform (or pipeline) that produced Output.
+// ProcessMS is the measured wall-clock time spent in the transform
+// itself, summed over all attempts, as opposed to the simulated
+// DelayMS. Source and Seq are copied from the Task, so Seq recovers
+// the dispatch order of results written in completion order. SHA256,
+// if the worker was asked for checksums, is the hex SHA-256 of Output.
+// SubIndex numbers the results of a task split by a FanOut, from 1; it
+// is 0 for a task that produced a single result. StartedAt and
+// FinishedAt are the wall clock times at which the worker began the
+// task, before the simulated delay, and finished it, for laying
+// results on a timeline with other systems' logs. Tags is copied from
+// the Task.
+type Result struct {
+    WorkerID  int     `json:"worker_id"`
+    TaskID    int     `json:"task_id"`
+    Seq       int     `json:"seq"`
+    Input     string  `json:"input"`
+    Output    string  `json:"output"`
+    Transform string  `json:"transform"`
+    Length    int     `json:"length"`
+    DelayMS   int64   `json:"delay_ms"`
+    ProcessMS float64 `json:"process_ms"`
+    Retries   int     `json:"retries"`
+    Source    string  `json:"source,omitempty"`
+    SHA256    string  `json:"sha256,omitempty"`
+    SubIndex  int     `json:"sub_index,omitempty"`
+
+    Tags map[string]string `json:"tags,omitempty"`
+
+    StartedAt  time.Time `json:"started_at"`
+    FinishedAt time.Time `json:"finished_at"`
+}
+
+// String formats the result as the human-readable line used by the
+// text output format and the console log.
+func (r Result) String() string {
+    return r.line(false)
+}
+
+// line is String, or with deterministic set the same line without the
+// fields that depend on scheduling and timing: the worker, the
+// simulated delay and the measured process time.
+func (r Result) line(deterministic bool) string {
+    source := ""
+    if r.Source != "" {
+        source = "source=" + r.Source + ", "
+    }
+    sub := ""
+    if r.SubIndex > 0 {
+        sub = "." + strconv.Itoa(r.SubIndex)
+    }
+    tags := ""
+    if len(r.Tags) > 0 {
+        tags = " [" + r.TagString() + "]"
+    }
+    if deterministic {
+        return fmt.Sprintf(
+            "Task-%d%s: %q -> %q (seq=%d, %stransform=%s, len=%d, retries=%d)%s",
+            r.TaskID, sub, r.Input, r.Output, r.Seq, source, r.Transform, r.Length, r.Retries, tags,
+        )
+    }
+    return fmt.Sprintf(
+        "Worker-%d processed Task-%d%s: %q -> %q (seq=%d, %stransform=%s, len=%d, delay=%dms, process=%.3fms, retries=%d)%s",
+        r.WorkerID, r.TaskID, sub, r.Input, r.Output, r.Seq, source, r.Transform, r.Length, r.DelayMS, r.ProcessMS, r.Retries, tags,
+    )
+}
+
+// TagString formats r.Tags as space-separated key=value pairs, sorted
+// by key, or "" without tags; the text line shows it in brackets.
+func (r Result) TagString() string {
+    keys := make([]string, 0, len(r.Tags))
+    for key := range r.Tags {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    pairs := make([]string, len(keys))
+    for i, key := range keys {
+        pairs[i] = key + "=" + r.Tags[key]
+    }
+    return strings.Join(pairs, " ")
+}
+
+// Failure records a task that could not be processed even after all
+// retries were used up. It implements error so that Worker.Process
+// can return it directly.
+type Failure struct {
+    Task     Task
+    WorkerID int
+    Attempts int
+    Err      error
+}
+
+// Error describes the failed task and the last processing error.
+func (f *Failure) Error() string {
+    return fmt.Sprintf("task %d failed after %d attempts: %v", f.Task.ID, f.Attempts, f.Err)
+}
+
+// Unwrap returns the last processing error.
+func (f *Failure) Unwrap() error {
+    return f.Err
+}
+
+// WorkerStats records how much work a single worker did. Each worker
+// owns its own WorkerStats value, so no locking is needed while it
+// runs; callers read them once the pool has finished.
+type WorkerStats struct {
+    WorkerID       int
+    TasksProcessed int
+    TotalDelay     time.Duration
+}
+
+// AverageDelay returns the mean simulated delay per processed task.
+func (s WorkerStats) AverageDelay() time.Duration {
+    if s.TasksProcessed == 0 {
+        return 0
+    }
+    return s.TotalDelay / time.Duration(s.TasksProcessed)
+}
@@ -0,0 +1,45 @@
+package processor
+
+import (
+    "fmt"
+    "io"
+    "text/template"
+)
+
+// DefaultTemplate is the text/template equivalent of Result.String,
+// the line format used by the text output.
+const DefaultTemplate = `Worker-{{.WorkerID}} processed Task-{{.TaskID}}{{with .SubIndex}}.{{.}}{{end}}: {{printf "%q" .Input}} -> {{printf "%q" .Output}} ` +
+    `(seq={{.Seq}}, {{with .Source}}source={{.}}, {{end}}transform={{.Transform}}, len={{.Length}}, delay={{.DelayMS}}ms, ` +
+    `process={{printf "%.3f" .ProcessMS}}ms, retries={{.Retries}}){{with .TagString}} [{{.}}]{{end}}`
+
+// TemplateEncoder returns an encoder that writes one line per result,
+// rendered by executing the text/template source text against the
+// Result and ended by a newline. A template that does not parse, or
+// that fails on a zero Result (for example by naming a field Result
+// does not have), is reported here rather than part-way through a run.
+func TemplateEncoder(text string) (func(w io.Writer, results []Result) error, error) {
+    return templateEncoder(text, "\n")
+}
+
+// templateEncoder is TemplateEncoder with each line ended by delimiter.
+func templateEncoder(text, delimiter string) (func(w io.Writer, results []Result) error, error) {
+    tmpl, err := template.New("result").Option("missingkey=error").Parse(text)
+    if err != nil {
+        return nil, fmt.Errorf("processor: invalid template: %w", err)
+    }
+    if err := tmpl.Execute(io.Discard, Result{}); err != nil {
+        return nil, fmt.Errorf("processor: invalid template: %w", err)
+    }
+
+    return func(w io.Writer, results []Result) error {
+        for _, result := range results {
+            if err := tmpl.Execute(w, result); err != nil {
+                return err
+            }
+            if _, err := io.WriteString(w, delimiter); err != nil {
+                return err
+            }
+        }
+        return nil
+    }, nil
+}
@@ -0,0 +1,170 @@
+package processor
+
+import (
+    "encoding/base64"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Transform turns a task's input data into its output data.
+type Transform func(string) string
+
+// Transforms holds the built-in transforms, keyed by name.
+var Transforms = map[string]Transform{
+    "upper":     strings.ToUpper,
+    "lower":     strings.ToLower,
+    "trim":      strings.TrimSpace,
+    "reverse":   reverseString,
+    "wordcount": wordCount,
+    "normalize": NormalizeSpace,
+}
+
+// TransformNames returns the names of the built-in transforms,
+// sorted, for help and error messages.
+func TransformNames() []string {
+    names := make([]string, 0, len(Transforms))
+    for name := range Transforms {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// reverseString reverses s rune by rune, so multibyte characters
+// stay intact.
+func reverseString(s string) string {
+    runes := []rune(s)
+    for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+        runes[i], runes[j] = runes[j], runes[i]
+    }
+    return string(runes)
+}
+
+// NormalizeSpace collapses every run of whitespace in s, tabs and
+// newlines included, to a single space and trims it from both ends.
+func NormalizeSpace(s string) string {
+    return strings.Join(strings.Fields(s), " ")
+}
+
+// wordCount replaces s with the number of whitespace-separated words
+// it contains.
+func wordCount(s string) string {
+    return strconv.Itoa(len(strings.Fields(s)))
+}
+
+// FanOut splits one output into several, for transforms such as
+// tokenization that map a task to many results; see Config.FanOut.
+type FanOut func(string) []string
+
+// FanOuts holds the built-in fan-out transforms, keyed by name.
+var FanOuts = map[string]FanOut{
+    "words": strings.Fields,
+    "lines": splitLines,
+    "chars": splitChars,
+}
+
+// FanOutNames returns the names of the built-in fan-out transforms,
+// sorted, for help and error messages.
+func FanOutNames() []string {
+    names := make([]string, 0, len(FanOuts))
+    for name := range FanOuts {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Decoder turns a task's data, as loaded, into the data its transform
+// sees, for input carried in an encoding; see Config.Decode. An error
+// means the data is malformed.
+type Decoder func(string) (string, error)
+
+// Decoders holds the built-in decoders, keyed by name.
+var Decoders = map[string]Decoder{
+    "base64": decodeBase64,
+}
+
+// DecoderNames returns the names of the built-in decoders, sorted, for
+// help and error messages.
+func DecoderNames() []string {
+    names := make([]string, 0, len(Decoders))
+    for name := range Decoders {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// decodeBase64 decodes standard base64, padded or not, ignoring
+// surrounding white space.
+func decodeBase64(s string) (string, error) {
+    s = strings.TrimSpace(s)
+    encoding := base64.StdEncoding
+    if len(s)%4 != 0 {
+        encoding = base64.RawStdEncoding
+    }
+    data, err := encoding.DecodeString(s)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
+
+// splitLines splits s into its lines, without line endings, skipping
+// empty ones.
+func splitLines(s string) []string {
+    var lines []string
+    for _, line := range strings.Split(s, "\n") {
+        if line = strings.TrimSuffix(line, "\r"); line != "" {
+            lines = append(lines, line)
+        }
+    }
+    return lines
+}
+
+// splitChars splits s into its characters (runes).
+func splitChars(s string) []string {
+    return strings.Split(s, "")
+}
+
+// Pipeline is an ordered list of built-in transform names, applied
+// one after another with each step's output feeding the next.
+type Pipeline []string
+
+// NewPipeline builds a Pipeline from transform names, failing on the
+// first name that is not a key of Transforms.
+func NewPipeline(names ...string) (Pipeline, error) {
+    for _, name := range names {
+        if _, ok := Transforms[name]; !ok {
+            return nil, fmt.Errorf("processor: unknown transform %q (choose from %s)",
+                name, strings.Join(TransformNames(), ", "))
+        }
+    }
+    return Pipeline(names), nil
+}
+
+// Transform composes the pipeline's steps into a single Transform.
+// An empty pipeline is the identity transform.
+func (p Pipeline) Transform() Transform {
+    steps := make([]Transform, len(p))
+    for i, name := range p {
+        steps[i] = Transforms[name]
+    }
+    return func(s string) string {
+        for _, step := range steps {
+            s = step(s)
+        }
+        return s
+    }
+}
+
+// String describes the pipeline as its step names joined with "|",
+// or "identity" if it is empty.
+func (p Pipeline) String() string {
+    if len(p) == 0 {
+        return "identity"
+    }
+    return strings.Join(p, "|")
+}
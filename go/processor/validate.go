@@ -0,0 +1,57 @@
+package processor
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "strings"
+)
+
+// CountRecords reads back the results file at path, written in format
+// ("text", "json" or "csv"), and returns how many results it holds:
+// the newline-ended records of a text file (FileWriter.CountRecords
+// takes another Delimiter), the elements of a JSON array, or the rows
+// of a CSV file after its header. A file that does not decode, such as
+// a JSON array cut short or a text file whose last record lacks its
+// delimiter, is an error. Files ending in ".gz" are decompressed. A
+// text file must not hold an appended summary, and its records must
+// not contain the delimiter themselves.
+func CountRecords(path, format string) (int, error) {
+    return countRecords(path, encoding{format: format})
+}
+
+// countRecords implements CountRecords and FileWriter.CountRecords.
+func countRecords(path string, enc encoding) (int, error) {
+    data, err := readInputFile(path)
+    if err != nil {
+        return 0, err
+    }
+    switch enc.format {
+    case "", "text":
+        delimiter := enc.textDelimiter()
+        if data != "" && !strings.HasSuffix(data, delimiter) {
+            return 0, fmt.Errorf("%s: last record is not terminated", path)
+        }
+        return strings.Count(data, delimiter), nil
+    case "json":
+        var records []json.RawMessage
+        if err := json.Unmarshal([]byte(data), &records); err != nil {
+            return 0, fmt.Errorf("%s: %w", path, err)
+        }
+        return len(records), nil
+    case "csv":
+        rows, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+        if err != nil {
+            return 0, fmt.Errorf("%s: %w", path, err)
+        }
+        return max(len(rows)-1, 0), nil
+    }
+    return 0, fmt.Errorf("unknown output format %q", enc.format)
+}
+
+// ValidateOutput checks, with CountRecords, that the results file at
+// path decodes and holds exactly want results, as a guard against a
+// truncated or corrupt write.
+func ValidateOutput(path, format string, want int) error {
+    return FileWriter{Path: path, Format: format}.Validate(want)
+}
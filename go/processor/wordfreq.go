@@ -0,0 +1,200 @@
+package processor
+
+import (
+    "bufio"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "unicode"
+)
+
+// WordCount is one entry of a word-frequency table.
+type WordCount struct {
+    Word  string `json:"word"`
+    Count int    `json:"count"`
+}
+
+// WordFrequency counts the words across all of config's tasks. It has
+// the same fan-out/fan-in shape as RunReport: a producer feeds the
+// tasks to Workers goroutines, each of which tokenizes a task's data
+// into a partial count, and a single reducer goroutine merges the
+// partials into the returned map. Words are the whitespace-separated
+// fields of the data, lower-cased, with leading and trailing
+// punctuation removed.
+//
+// Context, Tasks or Stream, Workers, BufferSize, Rate, Filter,
+// Progress and Logger apply as for RunReport; the transform, retry,
+// delay and batching settings do not. If the context is cancelled, the
+// counts gathered so far are returned with the context's error.
+func WordFrequency(config Config) (map[string]int, error) {
+    if err := config.validate(); err != nil {
+        return nil, err
+    }
+
+    ctx := config.Context
+    if ctx == nil {
+        ctx = context.Background()
+    }
+    log := config.Logger
+    if log == nil {
+        log = discardLogger
+    }
+
+    // Reducer: merge each partial count into the totals
+    partials := make(chan map[string]int)
+    counts := make(map[string]int)
+    reduceDone := make(chan struct{})
+    go func() {
+        for partial := range partials {
+            for word, n := range partial {
+                counts[word] += n
+            }
+        }
+        close(reduceDone)
+    }()
+
+    // Mappers: one partial count per task
+    tasks := make(chan Task, config.BufferSize)
+    var wg sync.WaitGroup
+    for i := 0; i < config.Workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                select {
+                case <-ctx.Done():
+                    return
+                case task, ok := <-tasks:
+                    if !ok {
+                        return
+                    }
+                    partials <- countWords(task.Data)
+                    config.Progress.add()
+                }
+            }
+        }()
+    }
+
+    next := config.taskFeed(ctx)
+    if config.Filter != nil {
+        filtered := 0
+        next = skipFeed(next, func(task Task) bool { return !config.Filter.MatchString(task.Data) }, &filtered, config.Progress)
+        defer func() { log.Info("filtered out tasks", "count", filtered) }()
+    }
+    produce(ctx, next, config.Rate, log, func(task Task) bool {
+        select {
+        case tasks <- task:
+            return true
+        case <-ctx.Done():
+            return false
+        }
+    })
+
+    close(tasks)
+    wg.Wait()
+    close(partials)
+    <-reduceDone
+
+    return counts, ctx.Err()
+}
+
+// countWords tokenizes data into a word -> occurrences map.
+func countWords(data string) map[string]int {
+    partial := make(map[string]int)
+    for _, field := range strings.Fields(data) {
+        word := strings.TrimFunc(strings.ToLower(field), func(r rune) bool {
+            return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+        })
+        if word != "" {
+            partial[word]++
+        }
+    }
+    return partial
+}
+
+// TopWords returns the n most frequent words in counts, most frequent
+// first and alphabetically among equal counts. n <= 0 returns them all.
+func TopWords(counts map[string]int, n int) []WordCount {
+    words := make([]WordCount, 0, len(counts))
+    for word, count := range counts {
+        words = append(words, WordCount{Word: word, Count: count})
+    }
+    sort.Slice(words, func(i, j int) bool {
+        if words[i].Count != words[j].Count {
+            return words[i].Count > words[j].Count
+        }
+        return words[i].Word < words[j].Word
+    })
+    if n > 0 && n < len(words) {
+        words = words[:n]
+    }
+    return words
+}
+
+// EncodeWordCounts writes a word-frequency table to w in format: "text"
+// (or empty) for "<count>\t<word>" lines, "json" for an indented array,
+// or "csv" with a word,count header.
+func EncodeWordCounts(w io.Writer, format string, words []WordCount) error {
+    switch format {
+    case "", "text":
+        for _, wc := range words {
+            if _, err := fmt.Fprintf(w, "%d\t%s\n", wc.Count, wc.Word); err != nil {
+                return err
+            }
+        }
+        return nil
+
+    case "json":
+        data, err := json.MarshalIndent(words, "", "  ")
+        if err != nil {
+            return err
+        }
+        _, err = w.Write(append(data, '\n'))
+        return err
+
+    case "csv":
+        writer := csv.NewWriter(w)
+        if err := writer.Write([]string{"word", "count"}); err != nil {
+            return err
+        }
+        for _, wc := range words {
+            if err := writer.Write([]string{wc.Word, strconv.Itoa(wc.Count)}); err != nil {
+                return err
+            }
+        }
+        writer.Flush()
+        return writer.Error()
+    }
+    return fmt.Errorf("processor: unknown output format %q", format)
+}
+
+// WriteWordCounts writes a word-frequency table to filename with
+// EncodeWordCounts; a name ending in ".gz" is gzip-compressed.
+func WriteWordCounts(filename, format string, words []WordCount) error {
+    return FileWriter{Path: filename, Format: format}.WriteWordCounts(words)
+}
+
+// WriteWordCounts is the package-level WriteWordCounts into w.Path in
+// w.Format, honouring w.InPlace.
+func (w FileWriter) WriteWordCounts(words []WordCount) error {
+    file, err := createFile(w.Path, w.InPlace)
+    if err != nil {
+        return err
+    }
+    writer := bufio.NewWriter(file)
+    if err := EncodeWordCounts(writer, w.Format, words); err != nil {
+        discardFile(file)
+        return err
+    }
+    if err := writer.Flush(); err != nil {
+        discardFile(file)
+        return err
+    }
+    return file.Close()
+}
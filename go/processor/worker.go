@@ -0,0 +1,518 @@
+package processor
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "math/rand"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// ErrTaskTimeout is the kind of a Failure whose task took longer than
+// the worker's TaskTimeout.
+var ErrTaskTimeout = errors.New("task timed out")
+
+// ErrTaskCancelled is the kind of a Failure whose task was cancelled
+// on its own, through Config.Cancel or by cancelling the context passed
+// to Worker.Process with it as the cause. Such tasks are not retried.
+var ErrTaskCancelled = errors.New("task cancelled")
+
+// ErrTransformPanic is the kind of a Failure whose transform panicked
+// on the task's data. The panic is recovered, so the
+// worker carries on with its next task; such tasks are not retried.
+var ErrTransformPanic = errors.New("transform panicked")
+
+// Worker holds the settings and running statistics of a single
+// worker in the pool. Run builds one Worker per goroutine, but a
+// Worker can also be used on its own to process individual tasks.
+type Worker struct {
+    ID         int
+    Transform  Transform
+    MaxRetries int
+
+    // Backoff sets the wait between retries; the zero Backoff waits a
+    // fixed 100ms.
+    Backoff Backoff
+
+    // FanOut, if set, makes ProcessAll split each output into several
+    // results; see Config.FanOut. Process ignores it.
+    FanOut FanOut
+
+    // TaskTimeout bounds how long a single task may take, including
+    // its simulated delay and retries; 0 means no limit. A task that
+    // runs over is abandoned and reported as a Failure wrapping
+    // ErrTaskTimeout. A transform that never returns keeps its
+    // goroutine alive, but the worker moves on to the next task.
+    TaskTimeout time.Duration
+
+    // IdleTimeout makes run (and runBatches) return once no task has
+    // arrived for this long; 0 means wait for as long as the channel is
+    // open.
+    IdleTimeout time.Duration
+
+    // TransformName is recorded in each Result to say which transform
+    // produced it.
+    TransformName string
+
+    // Rand drives the simulated delay and the jitter of an exponential
+    // Backoff. It is not safe for concurrent use, so every Worker needs
+    // its own.
+    Rand *rand.Rand
+
+    // Decode, if set, decodes each task's data before anything else is
+    // done with it; data that does not decode fails the task at once,
+    // as ErrInvalidInput, without retries. The results carry the
+    // decoded data as their Input.
+    Decode Decoder
+
+    // NoDelay skips the simulated delay, so tasks run at the speed of
+    // the transform; results then report a delay of 0. Rand is unused.
+    NoDelay bool
+
+    // Logger receives the worker's activity as structured records
+    // carrying worker_id and task_id attributes; nil discards them.
+    Logger *slog.Logger
+
+    // Checksum records the SHA-256 of each output in Result.SHA256.
+    Checksum bool
+
+    // Progress, if set, is advanced once per finished task.
+    Progress *Progress
+
+    // OnStart and OnStop, if set, are called with the worker's ID on
+    // the worker's goroutine: OnStart before it takes its first task,
+    // OnStop once it has stopped taking tasks, before the pool counts
+    // it as finished. They are the place to set up and tear down
+    // per-worker state such as buffers or connections.
+    OnStart func(workerID int)
+    OnStop  func(workerID int)
+
+    // Stats is updated as the worker processes tasks.
+    Stats WorkerStats
+
+    // split, with Config.SplitOutput, is the worker's own results file,
+    // written directly instead of sending results to the collector;
+    // metrics is then updated by the worker too.
+    split   *splitOutput
+    metrics *Metrics
+
+    // once, with Config.ExactlyOnce, skips tasks whose ID has already
+    // been processed successfully.
+    once *onceGuard
+
+    // cancels, with Config.Cancel, gives each task a context that can
+    // be cancelled by ID.
+    cancels *cancelRegistry
+
+    // pause, with Config.Pause, holds the worker back before each task
+    // while the run is paused.
+    pause *pauseGate
+
+    // inflight, with Config.MaxInFlight, is shared by the workers to
+    // cap how many of them process a task at once.
+    inflight *inflightLimit
+}
+
+// discardLogger is used wherever a nil *slog.Logger is configured.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// logger returns w.Logger (or a discarding logger) with the worker's
+// ID attached.
+func (w *Worker) logger() *slog.Logger {
+    if w.Logger == nil {
+        return discardLogger
+    }
+    return w.Logger.With("worker_id", w.ID)
+}
+
+// Process handles a single task:
+//
+//   - simulates processing (sleep),
+//   - transforms the data (with w.Transform, compute length), retrying
+//     up to w.MaxRetries times if processing fails,
+//   - updates w.Stats on success.
+//
+// If retries are exhausted or w.TaskTimeout expires it returns a
+// *Failure wrapping a *ProcessError. If ctx is cancelled before the
+// task finishes, the task is abandoned and ctx.Err() is returned,
+// unless its cause is ErrTaskCancelled: then only this task was
+// cancelled, and it is reported as a Failure of that kind.
+func (w *Worker) Process(ctx context.Context, task Task) (Result, error) {
+    log := w.logger().With("task_id", task.ID)
+    startedAt := time.Now()
+
+    input := task.Data
+    if w.Decode != nil {
+        decoded, err := w.Decode(input)
+        if err != nil {
+            log.Error("task data does not decode", "error", err)
+            err = &ProcessError{Kind: ErrInvalidInput, Err: fmt.Errorf("decoding data: %w", err)}
+            return Result{}, &Failure{Task: task, WorkerID: w.ID, Attempts: 1, Err: err}
+        }
+        input = decoded
+    }
+
+    // The task's own context adds the per-task deadline, if any.
+    taskCtx := ctx
+    if w.TaskTimeout > 0 {
+        var cancel context.CancelFunc
+        taskCtx, cancel = context.WithTimeout(ctx, w.TaskTimeout)
+        defer cancel()
+    }
+
+    retries := 0
+    // abandon reports why taskCtx ended: the whole run was cancelled,
+    // or just this task ran out of time.
+    abandon := func() (Result, error) {
+        if errors.Is(context.Cause(ctx), ErrTaskCancelled) {
+            log.Warn("task cancelled", "attempts", retries+1)
+            err := &ProcessError{Kind: ErrTaskCancelled, Err: errors.New("cancelled by request")}
+            return Result{}, &Failure{Task: task, WorkerID: w.ID, Attempts: retries + 1, Err: err}
+        }
+        if ctx.Err() != nil {
+            log.Warn("task abandoned: context cancelled", "error", ctx.Err())
+            return Result{}, ctx.Err()
+        }
+        log.Error("task timed out", "timeout", w.TaskTimeout, "attempts", retries+1)
+        err := &ProcessError{Kind: ErrTaskTimeout, Err: fmt.Errorf("exceeded %v", w.TaskTimeout)}
+        return Result{}, &Failure{Task: task, WorkerID: w.ID, Attempts: retries + 1, Err: err}
+    }
+
+    // Simulate computational work with a random delay in [200, 500) ms
+    var delay time.Duration
+    if !w.NoDelay {
+        delay = time.Duration(200+w.Rand.Intn(300)) * time.Millisecond
+        select {
+        case <-time.After(delay):
+        case <-taskCtx.Done():
+            return abandon()
+        }
+    }
+
+    // Processing: transform the data, retrying on failure
+    var processing time.Duration
+    attempt := func() (string, error) {
+        start := time.Now()
+        defer func() { processing += time.Since(start) }()
+        return w.transform(taskCtx, input)
+    }
+    output, err := attempt()
+    for err != nil && retries < w.MaxRetries && taskCtx.Err() == nil && !errors.Is(err, ErrTransformPanic) && !errors.Is(err, ErrInvalidInput) {
+        retries++
+        log.Warn("retrying task", "attempt", retries+1, "max_attempts", w.MaxRetries+1, "error", err)
+        if wait := w.Backoff.Delay(retries, w.Rand); wait > 0 {
+            select {
+            case <-time.After(wait):
+            case <-taskCtx.Done():
+                return abandon()
+            }
+        }
+        output, err = attempt()
+    }
+    if taskCtx.Err() != nil {
+        return abandon()
+    }
+    if err != nil {
+        log.Error("task failed", "attempts", retries+1, "error", err)
+        return Result{}, &Failure{Task: task, WorkerID: w.ID, Attempts: retries + 1, Err: err}
+    }
+
+    w.Stats.TasksProcessed++
+    w.Stats.TotalDelay += delay
+
+    var checksum string
+    if w.Checksum {
+        checksum = OutputChecksum(output)
+    }
+    return Result{
+        WorkerID:  w.ID,
+        TaskID:    task.ID,
+        Seq:       task.Seq,
+        Input:     input,
+        Output:    output,
+        Transform: w.TransformName,
+        Length:    utf8.RuneCountInString(output),
+        DelayMS:   delay.Milliseconds(),
+        ProcessMS: float64(processing) / float64(time.Millisecond),
+        Retries:   retries,
+        Source:    task.Source,
+        SHA256:    checksum,
+        Tags:      task.Tags,
+
+        StartedAt:  startedAt,
+        FinishedAt: time.Now(),
+    }, nil
+}
+
+// run is the worker goroutine: it takes Task values from the queue
+// and handles each one.
+//
+// When the queue is closed and drained, it logs a shutdown message and
+// returns, which decrements the WaitGroup counter. It also returns as
+// soon as ctx is cancelled, abandoning any task whose simulated work
+// has not finished yet, or once w.IdleTimeout passes without a task
+// (if the queue is a TimeoutQueue).
+func (w *Worker) run(ctx context.Context, queue TaskQueue, results chan<- Result, failures chan<- Failure, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    w.Stats.WorkerID = w.ID
+
+    log := w.logger()
+    log.Info("worker started")
+    w.start()
+    defer w.stop()
+
+    timeoutQueue, _ := queue.(TimeoutQueue)
+    for {
+        var task Task
+        var ok, idle bool
+        if w.IdleTimeout > 0 && timeoutQueue != nil {
+            task, ok, idle = timeoutQueue.NextTimeout(w.IdleTimeout)
+        } else {
+            task, ok = queue.Next()
+        }
+        if !ok {
+            switch {
+            case ctx.Err() != nil:
+                log.Info("worker cancelled", "error", ctx.Err())
+            case idle:
+                log.Info("idle shutdown", "idle_timeout", w.IdleTimeout)
+            default:
+                log.Info("task channel closed, shutting down")
+            }
+            break
+        }
+
+        log.Debug("processing task", "task_id", task.ID)
+        out, ok := w.handle(ctx, task, results, failures)
+        if !ok {
+            break
+        }
+        for _, result := range out {
+            log.Debug("task processed",
+                "task_id", result.TaskID,
+                "input", result.Input,
+                "output", result.Output,
+                "length", result.Length,
+                "sub_index", result.SubIndex,
+                "delay_ms", result.DelayMS,
+                "retries", result.Retries,
+            )
+        }
+    }
+
+    log.Info("worker completed")
+}
+
+// runBatches is the batch-mode counterpart of run: it reads []Task
+// batches from the batches channel and handles every task in each,
+// emitting one Result per task but logging once per batch. Like run,
+// it returns once w.IdleTimeout passes without a batch.
+func (w *Worker) runBatches(ctx context.Context, batches <-chan []Task, results chan<- Result, failures chan<- Failure, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    w.Stats.WorkerID = w.ID
+
+    log := w.logger()
+    log.Info("worker started")
+    w.start()
+    defer w.stop()
+
+loop:
+    for {
+        var idle <-chan time.Time
+        if w.IdleTimeout > 0 {
+            idle = time.After(w.IdleTimeout)
+        }
+        var batch []Task
+        select {
+        case <-ctx.Done():
+            log.Info("worker cancelled", "error", ctx.Err())
+            break loop
+        case <-idle:
+            log.Info("idle shutdown", "idle_timeout", w.IdleTimeout)
+            break loop
+        case b, ok := <-batches:
+            if !ok {
+                log.Info("batch channel closed, shutting down")
+                break loop
+            }
+            batch = b
+        }
+
+        log.Debug("processing batch", "size", len(batch), "first_task_id", batch[0].ID)
+        produced := 0
+        for _, task := range batch {
+            out, ok := w.handle(ctx, task, results, failures)
+            if !ok {
+                break loop
+            }
+            produced += len(out)
+        }
+        log.Debug("batch processed", "size", len(batch), "results", produced)
+    }
+
+    log.Info("worker completed")
+}
+
+// start and stop run the OnStart and OnStop hooks, if set.
+func (w *Worker) start() {
+    if w.OnStart != nil {
+        w.OnStart(w.ID)
+    }
+}
+
+func (w *Worker) stop() {
+    if w.OnStop != nil {
+        w.OnStop(w.ID)
+    }
+}
+
+// handle processes one task and records the outcome: its Results (one,
+// or any number with a FanOut) are sent on the results channel (or
+// written to the worker's split output) and returned, or the Failure is
+// sent on the failures channel and nil is returned. A task skipped by
+// ExactlyOnce also returns nil. It reports false if ctx was cancelled
+// and the worker should stop.
+func (w *Worker) handle(ctx context.Context, task Task, results chan<- Result, failures chan<- Failure) ([]Result, bool) {
+    if !w.once.claim(task.ID) {
+        w.logger().Debug("skipping task already processed", "task_id", task.ID)
+        w.Progress.skip()
+        return nil, true
+    }
+    out, err := w.process(ctx, task)
+    w.once.finish(task.ID, err == nil)
+    var failure *Failure
+    if errors.As(err, &failure) {
+        failures <- *failure
+        w.Progress.add()
+        return nil, true
+    }
+    if err != nil {
+        return nil, false
+    }
+    w.Progress.add()
+
+    for _, result := range out {
+        if w.split != nil {
+            w.split.write(result)
+            w.metrics.complete()
+        } else {
+            results <- result
+        }
+    }
+
+    return out, true
+}
+
+// ProcessAll is Process for a Worker with a FanOut: the Result is split
+// into one Result per part of its output, numbered by SubIndex from 1,
+// each with the part's length (and checksum) but the task's input and
+// timings. A panic in FanOut fails the task with ErrTransformPanic.
+// Without a FanOut it returns Process's single Result.
+func (w *Worker) ProcessAll(ctx context.Context, task Task) ([]Result, error) {
+    result, err := w.Process(ctx, task)
+    if err != nil {
+        return nil, err
+    }
+    if w.FanOut == nil {
+        return []Result{result}, nil
+    }
+
+    parts, err := fanOutData(result.Output, w.FanOut)
+    if err != nil {
+        w.logger().Error("task failed", "task_id", task.ID, "error", err)
+        // Process counted the task as processed; it failed after all.
+        w.Stats.TasksProcessed--
+        w.Stats.TotalDelay -= time.Duration(result.DelayMS) * time.Millisecond
+        return nil, &Failure{Task: task, WorkerID: w.ID, Attempts: result.Retries + 1, Err: err}
+    }
+    results := make([]Result, len(parts))
+    for i, part := range parts {
+        r := result
+        r.Output, r.Length, r.SubIndex = part, utf8.RuneCountInString(part), i+1
+        if w.Checksum {
+            r.SHA256 = OutputChecksum(part)
+        }
+        results[i] = r
+    }
+    return results, nil
+}
+
+// process is ProcessAll under the task's own context from w.cancels,
+// so that it can be cancelled by ID, once w.pause lets it start and it
+// holds a slot of w.inflight. A task cancelled before it starts is
+// reported as a Failure without being attempted.
+func (w *Worker) process(ctx context.Context, task Task) ([]Result, error) {
+    w.pause.wait(ctx)
+    if !w.inflight.acquire(ctx) {
+        return nil, ctx.Err()
+    }
+    defer w.inflight.release()
+    taskCtx, done, ok := w.cancels.begin(ctx, task.ID)
+    if !ok {
+        w.logger().Warn("task cancelled before it started", "task_id", task.ID)
+        err := &ProcessError{Kind: ErrTaskCancelled, Err: errors.New("cancelled by request")}
+        return nil, &Failure{Task: task, WorkerID: w.ID, Err: err}
+    }
+    defer done()
+    return w.ProcessAll(taskCtx, task)
+}
+
+// transform runs processData with w.Transform. With a TaskTimeout it
+// runs in its own goroutine so a slow transform can be abandoned when
+// ctx expires; the goroutine finishes on its own in the background.
+func (w *Worker) transform(ctx context.Context, input string) (string, error) {
+    if w.TaskTimeout <= 0 {
+        return processData(input, w.Transform)
+    }
+
+    type outcome struct {
+        output string
+        err    error
+    }
+    done := make(chan outcome, 1)
+    go func() {
+        output, err := processData(input, w.Transform)
+        done <- outcome{output, err}
+    }()
+
+    select {
+    case o := <-done:
+        return o.output, o.err
+    case <-ctx.Done():
+        return "", ctx.Err()
+    }
+}
+
+// fanOutData splits output with fanOut, reporting a panic in fanOut
+// as ErrTransformPanic.
+func fanOutData(output string, fanOut FanOut) (parts []string, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = &ProcessError{Kind: ErrTransformPanic, Err: fmt.Errorf("fan-out: %v", r)}
+        }
+    }()
+    return fanOut(output), nil
+}
+
+// processData is the processing step applied to each task's data: it
+// returns the data passed through transform. Input that is not valid
+// UTF-8 cannot be processed and is reported as ErrInvalidInput, and a
+// panic in transform as ErrTransformPanic.
+func processData(input string, transform Transform) (output string, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = &ProcessError{Kind: ErrTransformPanic, Err: fmt.Errorf("%v", r)}
+        }
+    }()
+
+    if !utf8.ValidString(input) {
+        return "", &ProcessError{Kind: ErrInvalidInput, Err: errors.New("data is not valid UTF-8")}
+    }
+    return transform(input), nil
+}
@@ -0,0 +1,127 @@
+package processor
+
+import (
+    "context"
+    "errors"
+    "math/rand"
+    "testing"
+    "time"
+)
+
+func TestResultLengthCountsRunes(t *testing.T) {
+    tests := []struct {
+        data  string
+        runes int
+        bytes int
+    }{
+        {"cafe", 4, 4},
+        {"café", 4, 5},
+        {"日本語", 3, 9},
+        {"", 0, 0},
+    }
+    tasks := make([]Task, len(tests))
+    for i, tt := range tests {
+        tasks[i] = Task{ID: i + 1, Data: tt.data}
+    }
+    results, err := Run(Config{Tasks: tasks, Workers: 2, Ordered: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(results) != len(tests) {
+        t.Fatalf("got %d results, want %d", len(results), len(tests))
+    }
+    for i, tt := range tests {
+        r := results[i]
+        if r.Length != tt.runes {
+            t.Errorf("%q: Length = %d, want %d characters", tt.data, r.Length, tt.runes)
+        }
+        if got := len(r.Output); got != tt.bytes {
+            t.Errorf("%q: len(Output) = %d bytes, want %d", tt.data, got, tt.bytes)
+        }
+    }
+}
+
+func TestTaskTimeoutWorkerRecovers(t *testing.T) {
+    // The slow transform blocks on "slow" until the test ends. The
+    // timeout leaves room for the simulated delay of up to 500ms.
+    release := make(chan struct{})
+    defer close(release)
+    slow := func(s string) string {
+        if s == "slow" {
+            <-release
+        }
+        return s
+    }
+    tasks := []Task{{ID: 1, Data: "slow"}, {ID: 2, Data: "a"}, {ID: 3, Data: "b"}}
+    config := Config{Tasks: tasks, Workers: 1, Ordered: true, Transform: slow, TaskTimeout: time.Second}
+
+    start := time.Now()
+    report, err := RunReport(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed > 3*time.Second {
+        t.Errorf("run took %v, the timeout did not free the worker", elapsed)
+    }
+    if len(report.Failures) != 1 || report.Failures[0].Task.ID != 1 || !errors.Is(&report.Failures[0], ErrTaskTimeout) {
+        t.Fatalf("failures = %v, want Task-1 timed out", report.Failures)
+    }
+    // The single worker carried on with the other tasks.
+    results := report.Results
+    if len(results) != 2 || results[0].TaskID != 2 || results[1].TaskID != 3 {
+        t.Fatalf("results = %v, want Task-2 and Task-3", results)
+    }
+
+    w := &Worker{ID: 1, Transform: slow, Rand: rand.New(rand.NewSource(1)), TaskTimeout: time.Second}
+    if _, err := w.Process(context.Background(), tasks[0]); !errors.Is(err, ErrTaskTimeout) {
+        t.Errorf("Process(slow) error = %v, want ErrTaskTimeout", err)
+    }
+}
+
+func TestTransformPanicFailsOnlyItsTask(t *testing.T) {
+    explode := func(s string) string {
+        if s == "boom" {
+            panic("pathological input")
+        }
+        return s
+    }
+    tasks := []Task{{ID: 1, Data: "a"}, {ID: 2, Data: "boom"}, {ID: 3, Data: "b"}, {ID: 4, Data: "c"}}
+    report, err := RunReport(Config{Tasks: tasks, Workers: 2, Ordered: true, Transform: explode, MaxRetries: 3})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(report.Failures) != 1 || report.Failures[0].Task.ID != 2 {
+        t.Fatalf("failures = %v, want Task-2", report.Failures)
+    }
+    var ids []int
+    for _, r := range report.Results {
+        ids = append(ids, r.TaskID)
+    }
+    if len(ids) != 3 || ids[0] != 1 || ids[1] != 3 || ids[2] != 4 {
+        t.Fatalf("result IDs = %v, want [1 3 4]", ids)
+    }
+
+    // A panic is not retried.
+    w := &Worker{ID: 1, Transform: explode, Rand: rand.New(rand.NewSource(1)), MaxRetries: 3}
+    _, err = w.Process(context.Background(), tasks[1])
+    var failure *Failure
+    if !errors.As(err, &failure) || !errors.Is(err, ErrTransformPanic) || failure.Attempts != 1 {
+        t.Errorf("Process(boom) error = %v, want an ErrTransformPanic failure after 1 attempt", err)
+    }
+}
+
+func TestInvalidInputNotRetried(t *testing.T) {
+    w := &Worker{ID: 1, NoDelay: true, MaxRetries: 3, Backoff: Backoff{Base: time.Second}}
+    start := time.Now()
+    _, err := w.Process(context.Background(), Task{ID: 1, Data: "\xff"})
+    var failure *Failure
+    if !errors.As(err, &failure) || !errors.Is(err, ErrInvalidInput) {
+        t.Fatalf("Process error = %v, want an ErrInvalidInput failure", err)
+    }
+    if failure.Attempts != 1 {
+        t.Errorf("Attempts = %d, want 1", failure.Attempts)
+    }
+    if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+        t.Errorf("Process took %v, it waited for a retry", elapsed)
+    }
+}
@@ -0,0 +1,230 @@
+package processor
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+// ResultWriter is an output sink for the results of a run.
+type ResultWriter interface {
+    Write(results []Result) error
+}
+
+// FileWriter writes results to a file at Path, encoded as Format
+// (one of the keys of Encoders; empty means "text"). The file is
+// created or truncated on every Write, unless Append is set, in which
+// case results are added to the end as by AppendResults.
+//
+// Template, if set, is a text/template rendered for every line of the
+// text format in place of Result.String, as by TemplateEncoder; other
+// formats ignore it. Unlike replacing an entry of Encoders, it only
+// affects this writer. Delimiter ends every text record in place of a
+// newline, e.g. one of the Delimiters; empty means "\n".
+//
+// Deterministic leaves out the fields that depend on scheduling and
+// timing (WorkerID, DelayMS, ProcessMS, StartedAt and FinishedAt), so
+// that the output depends only on the tasks and the transform, and an
+// ordered run with any number of workers can be compared byte for byte
+// with a Sequential one: the text line drops them, JSON drops the keys
+// and CSV leaves the cells empty. A Template prints what it names.
+//
+// Write without Append writes to a temporary file that is renamed onto
+// Path once complete, unless InPlace is set; streamed and appended
+// output is always written in place.
+//
+// OnWritten, if set, is called by WriteStream with every result once
+// it has been written and flushed to the file, e.g. to Mark it in a
+// Checkpoint.
+type FileWriter struct {
+    Path          string
+    Format        string
+    Append        bool
+    Deterministic bool
+    Template      string
+    Delimiter     string
+
+    InPlace   bool
+    OnWritten func(Result)
+}
+
+// Write encodes results into w.Path.
+func (w FileWriter) Write(results []Result) error {
+    if w.Append {
+        return appendResults(w.Path, w.encoding(), results)
+    }
+    encode, err := w.encoding().encoder()
+    if err != nil {
+        return err
+    }
+    return writeFile(w.Path, encode, results, w.InPlace)
+}
+
+// WriteStream is the channel-based counterpart of Write: it writes
+// each result from the channel into w.Path as soon as it arrives, as
+// WriteResultsStream does, or as AppendResultsStream does when Append
+// is set, and returns the number written once the channel is closed.
+func (w FileWriter) WriteStream(results <-chan Result) (int, error) {
+    return w.WriteStreamTee(nil, results)
+}
+
+// WriteStreamTee is WriteStream that also writes every result, in the
+// same encoding, to tee as it arrives; see WriteResultsStreamTee.
+func (w FileWriter) WriteStreamTee(tee io.Writer, results <-chan Result) (int, error) {
+    var file io.WriteCloser
+    var continuing bool
+    var err error
+    if w.Append {
+        file, continuing, err = openAppend(w.Path, w.Format)
+    } else {
+        file, err = createOutput(w.Path)
+    }
+    if err != nil {
+        drain(results)
+        return 0, err
+    }
+    n, err := streamResults(teeTo(file, tee), w.encoding(), results, continuing, w.OnWritten)
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    return n, err
+}
+
+// CountRecords reads w.Path back and returns how many results it
+// holds in w.Format, with text records ended by w.Delimiter; see the
+// package-level CountRecords.
+func (w FileWriter) CountRecords() (int, error) {
+    return countRecords(w.Path, w.encoding())
+}
+
+// Validate checks, with CountRecords, that w.Path decodes and holds
+// exactly want results, as ValidateOutput does.
+func (w FileWriter) Validate(want int) error {
+    got, err := w.CountRecords()
+    if err != nil {
+        return err
+    }
+    if got != want {
+        return fmt.Errorf("%s holds %d results, want %d", w.Path, got, want)
+    }
+    return nil
+}
+
+// encoding returns w's format and settings.
+func (w FileWriter) encoding() encoding {
+    return encoding{format: w.Format, deterministic: w.Deterministic, template: w.Template, delimiter: w.Delimiter}
+}
+
+// String describes the destination for log messages.
+func (w FileWriter) String() string {
+    return w.Path
+}
+
+// StdoutWriter writes results to standard output, encoded as Format
+// (one of the keys of Encoders; empty means "text"), with
+// Template, Delimiter, Deterministic and OnWritten as for FileWriter.
+type StdoutWriter struct {
+    Format        string
+    Deterministic bool
+    Template      string
+    Delimiter     string
+    OnWritten     func(Result)
+}
+
+// Write encodes results onto os.Stdout.
+func (w StdoutWriter) Write(results []Result) error {
+    encode, err := w.encoding().encoder()
+    if err != nil {
+        return err
+    }
+    return encode(os.Stdout, results)
+}
+
+// WriteStream writes each result from the channel onto os.Stdout as
+// soon as it arrives, as StreamResults does, and returns the number
+// written once the channel is closed.
+func (w StdoutWriter) WriteStream(results <-chan Result) (int, error) {
+    return streamResults(os.Stdout, w.encoding(), results, false, w.OnWritten)
+}
+
+// encoding returns w's format and settings.
+func (w StdoutWriter) encoding() encoding {
+    return encoding{format: w.Format, deterministic: w.Deterministic, template: w.Template, delimiter: w.Delimiter}
+}
+
+// String describes the destination for log messages.
+func (w StdoutWriter) String() string {
+    return "standard output"
+}
+
+// MultiWriter writes the same results to each of its writers in turn,
+// so that one run can produce several formats without processing the
+// tasks again. Every writer is tried; their errors are joined.
+type MultiWriter []ResultWriter
+
+// Write passes results to every writer.
+func (m MultiWriter) Write(results []Result) error {
+    var errs []error
+    for _, w := range m {
+        if err := w.Write(results); err != nil {
+            errs = append(errs, fmt.Errorf("%v: %w", w, err))
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// String lists the destinations for log messages.
+func (m MultiWriter) String() string {
+    names := make([]string, len(m))
+    for i, w := range m {
+        names[i] = fmt.Sprint(w)
+    }
+    return strings.Join(names, ", ")
+}
+
+// encoding is an output format together with the settings of the
+// writer using it.
+type encoding struct {
+    format        string
+    deterministic bool
+    template      string
+    delimiter     string
+}
+
+// encoder returns the encoder for e: the entry in Encoders for its
+// format, defaulting to text, or one that applies e's settings.
+func (e encoding) encoder() (func(w io.Writer, results []Result) error, error) {
+    format := e.format
+    if format == "" {
+        format = "text"
+    }
+    if format == "text" && e.template != "" {
+        return templateEncoder(e.template, e.textDelimiter())
+    }
+    if format == "text" && (e.delimiter != "" || e.deterministic) {
+        return textEncoder(e.textDelimiter(), e.deterministic), nil
+    }
+    if e.deterministic {
+        switch format {
+        case "json":
+            return jsonEncoder(true), nil
+        case "csv":
+            return csvEncoder(true), nil
+        }
+    }
+    encode, ok := Encoders[format]
+    if !ok {
+        return nil, fmt.Errorf("processor: unknown output format %q", format)
+    }
+    return encode, nil
+}
+
+// textDelimiter is the delimiter ending e's text records.
+func (e encoding) textDelimiter() string {
+    if e.delimiter == "" {
+        return "\n"
+    }
+    return e.delimiter
+}
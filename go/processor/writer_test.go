@@ -0,0 +1,254 @@
+package processor
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestWriteStreamOnWrittenAfterFlush(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "stream.txt")
+    results := make(chan Result)
+    go func() {
+        for id := 1; id <= 3; id++ {
+            results <- Result{TaskID: id}
+        }
+        close(results)
+    }()
+    var marked []int
+    w := FileWriter{Path: path, OnWritten: func(r Result) {
+        // By the time a result is reported, its line is in the file.
+        data, err := os.ReadFile(path)
+        if err != nil {
+            t.Error(err)
+        }
+        lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+        if !strings.Contains(lines[len(lines)-1], fmt.Sprintf("Task-%d:", r.TaskID)) {
+            t.Errorf("OnWritten(Task-%d) before it was flushed: file holds %q", r.TaskID, data)
+        }
+        marked = append(marked, r.TaskID)
+    }}
+    if _, err := w.WriteStream(results); err != nil {
+        t.Fatal(err)
+    }
+    if len(marked) != 3 {
+        t.Errorf("OnWritten called for %v, want all 3 results", marked)
+    }
+}
+
+func TestDeterministicSequentialMatchesOrdered(t *testing.T) {
+    tasks := GenerateTasks(50)
+    // A short sleep spreads the tasks over the workers.
+    slowUpper := func(s string) string {
+        time.Sleep(time.Millisecond)
+        return strings.ToUpper(s)
+    }
+    runs := map[string]Config{
+        "sequential": {Tasks: tasks, Sequential: true, Workers: 1, Transform: slowUpper, NoDelay: true},
+        "ordered":    {Tasks: tasks, Workers: 8, Ordered: true, Transform: slowUpper, NoDelay: true},
+    }
+    for _, format := range []string{"text", "json", "csv"} {
+        t.Run(format, func(t *testing.T) {
+            dir := t.TempDir()
+            output := make(map[string]string)
+            workers := make(map[int]bool)
+            for name, config := range runs {
+                results, err := Run(config)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                for _, r := range results {
+                    workers[r.WorkerID] = true
+                }
+                w := FileWriter{Path: filepath.Join(dir, name), Format: format, Deterministic: true}
+                if err := w.Write(results); err != nil {
+                    t.Fatal(err)
+                }
+                data, err := os.ReadFile(w.Path)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                output[name] = string(data)
+            }
+            if len(workers) < 2 {
+                t.Fatalf("every task ran on the same worker; the comparison proves nothing")
+            }
+            if output["sequential"] != output["ordered"] {
+                t.Errorf("sequential output differs from ordered:\n%s\nvs\n%s", output["sequential"], output["ordered"])
+            }
+            for _, field := range []string{"Worker-", "process=", `"worker_id"`, `"delay_ms"`} {
+                if strings.Contains(output["ordered"], field) {
+                    t.Errorf("deterministic output has %q:\n%s", field, output["ordered"])
+                }
+            }
+        })
+    }
+}
+
+func TestFileWriterKeepsTimingsByDefault(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "out.txt")
+    r := Result{WorkerID: 3, TaskID: 1, DelayMS: 250, ProcessMS: 1.5}
+    if err := (FileWriter{Path: path}).Write([]Result{r}); err != nil {
+        t.Fatal(err)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, field := range []string{"Worker-3", "delay=250ms", "process=1.500ms"} {
+        if !strings.Contains(string(data), field) {
+            t.Errorf("output %q is missing %q", data, field)
+        }
+    }
+}
+func TestFileWriterTemplateIsPerWriter(t *testing.T) {
+    dir := t.TempDir()
+    results := []Result{{WorkerID: 1, TaskID: 7, Input: "a", Output: "A"}}
+    plain := FileWriter{Path: filepath.Join(dir, "plain.txt")}
+    custom := FileWriter{Path: filepath.Join(dir, "custom.txt"), Template: "{{.TaskID}}={{.Output}}"}
+    for _, w := range []FileWriter{custom, plain} {
+        if err := w.Write(results); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        path, want string
+    }{
+        {custom.Path, "7=A\n"},
+        {plain.Path, results[0].String() + "\n"},
+    }
+    for _, tt := range tests {
+        data, err := os.ReadFile(tt.path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(data) != tt.want {
+            t.Errorf("%s = %q, want %q", filepath.Base(tt.path), data, tt.want)
+        }
+    }
+}
+
+func TestFileWriterWriteStreamTemplate(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "stream.txt")
+    results := make(chan Result, 2)
+    results <- Result{TaskID: 1, Output: "A"}
+    results <- Result{TaskID: 2, Output: "B"}
+    close(results)
+    n, err := FileWriter{Path: path, Template: "{{.TaskID}}:{{.Output}}"}.WriteStream(results)
+    if err != nil || n != 2 {
+        t.Fatalf("WriteStream = %d, %v, want 2, nil", n, err)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := "1:A\n2:B\n"; string(data) != want {
+        t.Errorf("stream = %q, want %q", data, want)
+    }
+}
+
+func TestFileWriterDelimiter(t *testing.T) {
+    dir := t.TempDir()
+    results := []Result{{TaskID: 1, Output: "A"}, {TaskID: 2, Output: "B"}}
+    tests := []struct {
+        w    FileWriter
+        want string
+    }{
+        {FileWriter{Path: filepath.Join(dir, "lf.txt")}, results[0].String() + "\n" + results[1].String() + "\n"},
+        {FileWriter{Path: filepath.Join(dir, "nul.txt"), Delimiter: "\x00"}, results[0].String() + "\x00" + results[1].String() + "\x00"},
+        {FileWriter{Path: filepath.Join(dir, "crlf.txt"), Delimiter: "\r\n", Template: "{{.TaskID}}"}, "1\r\n2\r\n"},
+    }
+    for _, tt := range tests {
+        if err := tt.w.Write(results); err != nil {
+            t.Fatal(err)
+        }
+        data, err := os.ReadFile(tt.w.Path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        name := filepath.Base(tt.w.Path)
+        if string(data) != tt.want {
+            t.Errorf("%s = %q, want %q", name, data, tt.want)
+        }
+        if err := tt.w.Validate(len(results)); err != nil {
+            t.Errorf("%s: Validate: %v", name, err)
+        }
+    }
+}
+
+func TestFileWriterInPlace(t *testing.T) {
+    results := []Result{{TaskID: 1, Output: "A"}}
+    for _, inPlace := range []bool{false, true} {
+        dir := t.TempDir()
+        path := filepath.Join(dir, "out.txt")
+        if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+            t.Fatal(err)
+        }
+        before, err := os.Stat(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := (FileWriter{Path: path, InPlace: inPlace}).Write(results); err != nil {
+            t.Fatal(err)
+        }
+        after, err := os.Stat(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        // A rename puts a new file at path; writing in place keeps it.
+        if same := os.SameFile(before, after); same != inPlace {
+            t.Errorf("InPlace %v: same file after Write = %v", inPlace, same)
+        }
+        if after.Mode().Perm() != 0o600 {
+            t.Errorf("InPlace %v: mode = %v, want 0600 kept", inPlace, after.Mode().Perm())
+        }
+        if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+            t.Errorf("InPlace %v: %d files left in the directory, want 1", inPlace, len(entries))
+        }
+    }
+}
+
+func TestFileWriterNewFileMode(t *testing.T) {
+    dir := t.TempDir()
+    created, err := os.Create(filepath.Join(dir, "created"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    created.Close()
+    want, err := os.Stat(created.Name())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    results := []Result{{TaskID: 1, Input: "a", Output: "A"}}
+    for _, name := range []string{"out.txt", "out.txt.gz"} {
+        w := FileWriter{Path: filepath.Join(dir, name)}
+        if err := w.Write(results); err != nil {
+            t.Fatal(err)
+        }
+        info, err := os.Stat(w.Path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if info.Mode().Perm() != want.Mode().Perm() {
+            t.Errorf("%s: mode = %v, want %v as os.Create gives", name, info.Mode().Perm(), want.Mode().Perm())
+        }
+        file, err := openInput(w.Path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, err := io.ReadAll(file)
+        file.Close()
+        if err != nil {
+            t.Fatalf("%s: %v", name, err)
+        }
+        if string(data) != results[0].String()+"\n" {
+            t.Errorf("%s reads back as %q", name, data)
+        }
+    }
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// snapshotSignals and pauseSignals are empty where there is no SIGUSR1
+// or SIGUSR2, so snapshots and pausing are not available.
+var snapshotSignals, pauseSignals []os.Signal
@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// snapshotSignals are the signals that ask for a snapshot of the
+// results collected so far; see handleSnapshots.
+var snapshotSignals = []os.Signal{syscall.SIGUSR1}
+
+// pauseSignals pause a running job and resume it again, in turn; see
+// handlePause.
+var pauseSignals = []os.Signal{syscall.SIGUSR2}